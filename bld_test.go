@@ -2,21 +2,27 @@ package main
 
 import (
 	"testing"
+
+	"github.com/sevlyar/bld/engine"
 )
 
 func TestConfig(test *testing.T) {
-	defer exit()
-
 	verbose = true
 	TestCreateEnvironment(test)
 
-	conf := loadConfigs("build.json", "..")
-	conf.store("combined.json")
+	conf, err := engine.LoadConfig("build.json", "..", nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if err = conf.Store("combined.json"); err != nil {
+		test.Fatal(err)
+	}
 }
 
-func TestMain(test *testing.T) {
-	verbose = true
+func TestRun(test *testing.T) {
+	TestCreateEnvironment(test)
 
-	TestChdir(test)
-	main()
+	if err := run([]string{"-v", "build", "build.json", ".."}); err != nil {
+		test.Fatal(err)
+	}
 }
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sevlyar/bld/engine"
+)
+
+// command описывает команду утилиты.
+type command struct {
+	name string
+	// аргументы команды для справки
+	args  string
+	usage string
+	run   func(args []string) error
+}
+
+// список команд, первая команда выполняется, если команда не указана.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"build", "[<scenario> [<root-dir>]]",
+			"execute the scenario operations (default)", runBuild},
+		{"clean", "[<scenario> [<root-dir>]]",
+			"remove build outputs and the cache", runClean},
+		{"check", "[<scenario> [<root-dir>]]",
+			"validate the scenario and print all problems found", runCheck},
+		{"graph", "[<scenario> [<root-dir>]]",
+			"print the operation dependency graph", runGraph},
+		{"explain", "[<file> [<scenario> [<root-dir>]]]",
+			"explain why the file (or every source) would be processed",
+			runExplain},
+		{"compdb", "[<scenario> [<root-dir>]]",
+			"write compile_commands.json for clang tooling", runCompdb},
+	}
+}
+
+// findCommand возвращает команду с указанным именем или nil.
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// parseCommand возвращает команду, указанную первым аргументом, и ее
+// аргументы. Имя команды в первом аргументе всегда означает команду; если
+// первый аргумент не является именем команды (или аргументы не указаны),
+// выполняется первая команда (build) со всеми аргументами:
+// "bld <scenario> <root-dir>".
+func parseCommand(args []string) (*command, []string) {
+	if len(args) > 0 {
+		if cmd := findCommand(args[0]); cmd != nil {
+			return cmd, args[1:]
+		}
+	}
+	return commands[0], args
+}
+
+// newBuilder загружает и проверяет сценарий, указанный аргументами
+// [<scenario> [<root-dir>]] (или найденный, если они не указаны).
+func newBuilder(args []string) (*engine.Builder, error) {
+	if len(args) > 2 {
+		return nil, &engine.UsageError{Err: fmt.Errorf("too many arguments: %s",
+			strings.Join(args[2:], " "))}
+	}
+
+	opts := buildOpts
+	if len(args) > 0 {
+		opts.Scenario = args[0]
+	}
+	if len(args) > 1 {
+		opts.Root = args[1]
+	}
+	return engine.NewBuilder(opts)
+}
+
+// runBuild выполняет операции сценария.
+func runBuild(args []string) error {
+	b, err := newBuilder(args)
+	if err != nil {
+		return err
+	}
+	return b.Build()
+}
+
+// runClean удаляет файлы, созданные операциями, и сбрасывает
+// соответствующие записи кэша. Если операции не указаны опцией --ops,
+// удаляется весь кэш. С опцией --dry-run только выводит удаляемые файлы.
+func runClean(args []string) error {
+	b, err := newBuilder(args)
+	if err != nil {
+		return err
+	}
+
+	var ops []string
+	for _, name := range strings.Split(cleanOps, ",") {
+		if name = strings.TrimSpace(name); len(name) != 0 {
+			ops = append(ops, name)
+		}
+	}
+	return b.Clean(ops)
+}
+
+// runCheck проверяет сценарий (проверка выполняется при загрузке).
+func runCheck(args []string) error {
+	b, err := newBuilder(args)
+	if err != nil {
+		return err
+	}
+	fmt.Println("scenario", b.Scenario(), "is valid")
+	return nil
+}
+
+// runGraph выводит граф операций в формате, указанном опцией --format:
+// text (операции и их зависимости в виде "name: deps..."), dot (Graphviz) или
+// json. С опцией --files в граф добавляются файлы из кэша.
+func runGraph(args []string) error {
+	switch graphFormat {
+	case "text", "dot", "json":
+	default:
+		return &engine.UsageError{Err: fmt.Errorf("graph: unknown format '%s'",
+			graphFormat)}
+	}
+
+	b, err := newBuilder(args)
+	if err != nil {
+		return err
+	}
+	g, err := b.Graph(graphFiles)
+	if err != nil {
+		return err
+	}
+
+	switch graphFormat {
+	case "text":
+		g.WriteText(os.Stdout)
+	case "dot":
+		g.WriteDOT(os.Stdout)
+	default:
+		return g.WriteJSON(os.Stdout)
+	}
+	return nil
+}
+
+// runExplain выводит для каждого файла, который будет обработан операциями,
+// цепочку причин его обработки. Операции не выполняются, кэш не
+// изменяется. Первый аргумент ограничивает вывод одним файлом, путь к
+// которому указывается относительно текущей директории; если он не указан
+// или пуст, выводятся все файлы.
+func runExplain(args []string) error {
+	var name, file string
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if len(name) != 0 {
+		var err error
+		if file, err = filepath.Abs(name); err != nil {
+			return err
+		}
+		if _, err = os.Stat(file); err != nil {
+			return &engine.UsageError{Err: fmt.Errorf("explain: %w", err)}
+		}
+	}
+
+	b, err := newBuilder(args)
+	if err != nil {
+		return err
+	}
+	found, err := b.Explain(file)
+	switch {
+	case err != nil:
+		return err
+	case found:
+	case len(file) != 0:
+		fmt.Printf("%s would not be processed\n", name)
+	default:
+		fmt.Println("nothing would be processed")
+	}
+	return nil
+}
+
+// runCompdb сохраняет команды обработки исходных файлов в формате
+// compile_commands.json, ничего не выполняя.
+func runCompdb(args []string) error {
+	b, err := newBuilder(args)
+	if err != nil {
+		return err
+	}
+
+	list, err := b.CompileCommands()
+	if err != nil {
+		return err
+	}
+	if err = engine.WriteCompileCommands(compdbFile, list); err != nil {
+		return err
+	}
+	fmt.Printf("%d command(s) written to %s\n", len(list), compdbFile)
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCommand(test *testing.T) {
+	cases := []struct {
+		args, cmd, rest string
+	}{
+		{"", "build", ""},
+		{"a.json", "build", "a.json"},
+		{"a.json ..", "build", "a.json .."},
+		// имя команды всегда означает команду, даже если есть сценарий с
+		// таким именем
+		{"build", "build", ""},
+		{"build ..", "build", ".."},
+		{"clean", "clean", ""},
+		{"check", "check", ""},
+		{"clean clean ..", "clean", "clean .."},
+		{"explain ../a.c build ..", "explain", "../a.c build .."},
+	}
+	for _, c := range cases {
+		cmd, rest := parseCommand(strings.Fields(c.args))
+		if cmd.name != c.cmd || strings.Join(rest, " ") != c.rest {
+			test.Errorf("%q: command %s, args %q", c.args, cmd.name, rest)
+		}
+	}
+}
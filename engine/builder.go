@@ -0,0 +1,349 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Options - параметры сборки. Нулевые значения полей соответствуют
+// значениям по умолчанию.
+type Options struct {
+	// Рабочая директория, создается при необходимости. Если не указана,
+	// используется текущая директория или, если сценарий не указан,
+	// директория сборки найденного проекта.
+	WorkDir string
+	// Директория сборки относительно корневой директории найденного
+	// проекта, используется, если сценарий не указан.
+	BuildDir string
+	// Сценарий относительно корневой директории, расширение можно не
+	// указывать. Если не указан, проект ищется, начиная с рабочей
+	// директории.
+	Scenario string
+	// Корневая директория проекта относительно рабочей, по умолчанию "..".
+	Root string
+	// Файл кэша относительно рабочей директории, по умолчанию
+	// DefaultCacheFile.
+	CacheFile string
+
+	// Число одновременных вызовов утилиты не групповой операции, по
+	// умолчанию 1.
+	Jobs int
+	// Допустимый уровень вложенности макровызовов, по умолчанию
+	// DefaultMaxMacroLevel.
+	MaxMacroLevel int
+	// Вызов несуществующей переменной среды считается ошибкой.
+	StrictEnv bool
+
+	// Журнал, если nil - журнал не ведется.
+	Logger *log.Logger
+	// Получатели событий сборки.
+	Events []EventSink
+	// Операции не выполняются: командные строки утилит выводятся (если
+	// не указан Runner), кэш не изменяется, Clean только выводит
+	// удаляемые файлы.
+	DryRun bool
+	// Способ вызова утилит операций, по умолчанию ExecRunner.
+	Runner Runner
+	// Вывод утилит и сообщений о ходе сборки, по умолчанию os.Stdout.
+	Stdout io.Writer
+}
+
+// Builder выполняет команды над загруженным и проверенным сценарием.
+type Builder struct {
+	s         *session
+	opts      Options
+	cacheFile string
+
+	workDir  string
+	root     string
+	scenario string
+	conf     *Config
+	defs     Defines
+}
+
+// NewBuilder переходит в рабочую директорию, определяет корневую
+// директорию и сценарий (или ищет проект, если сценарий не указан),
+// загружает конфигурацию, разворачивает макроопределения и проверяет
+// сценарий. Проблемы, найденные при проверке, возвращаются в поле Problems
+// ошибки ConfigError.
+//
+// Пути в сценарии и кэше указываются относительно рабочей директории,
+// поэтому NewBuilder изменяет текущую директорию процесса.
+func NewBuilder(opts Options) (*Builder, error) {
+	b := &Builder{s: newSession(&opts), opts: opts, cacheFile: opts.CacheFile}
+	if len(b.cacheFile) == 0 {
+		b.cacheFile = DefaultCacheFile
+	}
+	if err := b.setup(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *Builder) setup() error {
+	if len(b.opts.WorkDir) != 0 {
+		if err := chdir(b.opts.WorkDir); err != nil {
+			return err
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	b.scenario, b.root = b.opts.Scenario, b.opts.Root
+	if len(b.root) == 0 {
+		b.root = ".."
+	}
+	if len(b.scenario) == 0 {
+		p, err := discoverProject(wd)
+		if err != nil {
+			return err
+		}
+		b.scenario = defaultScenario
+		if p != nil {
+			// сценарий не указан: корневая директория определяется по
+			// найденному сценарию, работа ведется в директории сборки
+			b.s.logf("project found: %s", filepath.Join(p.root, p.scenario))
+			b.scenario = p.scenario
+			if len(b.opts.WorkDir) == 0 {
+				wd = p.workDir(wd, b.opts.BuildDir, b.cacheFile)
+				if err = chdir(wd); err != nil {
+					return err
+				}
+			}
+			if b.root, err = filepath.Rel(wd, p.root); err != nil {
+				b.root = p.root
+			}
+		}
+	}
+	b.scenario = findScenario(b.root, b.scenario)
+	b.workDir = wd
+
+	b.s.logf("work dir: %s", wd)
+	b.s.logf("root dir: %s", b.root)
+	b.s.logf("scenario: %s", b.scenario)
+
+	if b.conf, err = loadConfig(b.scenario, b.root, b.s); err != nil {
+		return err
+	}
+	// неизвестные поля выводятся вместе с проблемами, найденными при
+	// проверке, в том числе если макроопределения не разворачиваются
+	problems := b.conf.problems
+	if b.defs, err = b.conf.Defines(b.root); err != nil {
+		if len(problems) == 0 {
+			return err
+		}
+		return &ConfigError{Problems: problems, Err: err}
+	}
+
+	if problems = append(problems, b.conf.validate(b.defs)...); len(problems) != 0 {
+		return &ConfigError{Problems: problems, Err: fmt.Errorf(
+			"scenario %s has %d problem(s)", b.scenario, len(problems))}
+	}
+	return nil
+}
+
+// chdir переходит в директорию, создавая ее при необходимости.
+func chdir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.Chdir(dir)
+}
+
+// WorkDir возвращает абсолютный путь к рабочей директории.
+func (b *Builder) WorkDir() string { return b.workDir }
+
+// Root возвращает путь к корневой директории относительно рабочей.
+func (b *Builder) Root() string { return b.root }
+
+// Scenario возвращает путь к сценарию относительно корневой директории.
+func (b *Builder) Scenario() string { return b.scenario }
+
+// Config возвращает загруженную конфигурацию.
+func (b *Builder) Config() *Config { return b.conf }
+
+// Defines возвращает развернутые макроопределения сценария.
+func (b *Builder) Defines() Defines { return b.defs }
+
+// readCache загружает кэш из файла кэша.
+func (b *Builder) readCache() (Cache, error) {
+	b.s.logf("read cache %s", b.cacheFile)
+	return ReadCache(b.cacheFile)
+}
+
+// writeCache сохраняет кэш в файл кэша.
+func (b *Builder) writeCache(cache Cache) error {
+	b.s.logf("write cache %s", b.cacheFile)
+	return cache.Write(b.cacheFile)
+}
+
+// Build выполняет операции сценария, обрабатывая только измененные файлы.
+func (b *Builder) Build() error {
+	cache, err := b.readCache()
+	if err != nil {
+		return err
+	}
+
+	for _, item := range b.conf.Ops {
+		if err = b.buildOp(cache, item); err != nil {
+			return err
+		}
+	}
+
+	if b.opts.DryRun {
+		return nil
+	}
+	return b.writeCache(cache)
+}
+
+// buildOp выполняет операцию сценария, сообщая о ее начале и завершении.
+func (b *Builder) buildOp(cache Cache, item *Operation) (err error) {
+	fmt.Fprintln(b.s.stdout, item.Descr)
+	b.s.logf("operation %s (%s)", item.Name, item.loc)
+
+	start := time.Now()
+	b.s.emit(&Event{Event: EventOpStart, Op: item.Name})
+	defer func() {
+		e := &Event{Event: EventOpFinish, Op: item.Name, Start: start}
+		files := len(item.targetFiles)
+		e.Files = &files
+		if err != nil {
+			e.Error = err.Error()
+		}
+		b.s.emit(e)
+	}()
+
+	if err = b.prepare(cache, item); err != nil || len(item.targetFiles) == 0 {
+		return err
+	}
+
+	outputs, err := item.outputFiles(item.targetFiles)
+	if err != nil {
+		return err
+	}
+	before, err := statFiles(outputs)
+	if err != nil {
+		return err
+	}
+	if err = item.Exec(); err != nil || b.opts.DryRun {
+		return err
+	}
+	after, err := statFiles(outputs)
+	if err != nil {
+		return err
+	}
+	for _, path := range cache.recordOutputs(item.Name, before, after) {
+		b.s.logf("operation %s produced %s", item.Name, path)
+	}
+	return nil
+}
+
+// prepare подставляет макроопределения в поля операции и составляет список
+// обрабатываемых файлов.
+func (b *Builder) prepare(cache Cache, op *Operation) error {
+	scope, err := op.Scope(b.defs)
+	if err != nil {
+		return err
+	}
+	if err = op.CacheOpts(scope); err != nil {
+		return err
+	}
+	return op.SearchFiles(b.root, ".", cache, scope)
+}
+
+// Clean удаляет файлы, созданные операциями ops, и сбрасывает
+// соответствующие записи кэша. Если операции не указаны, удаляются файлы
+// всех операций и весь кэш. С Options.DryRun только выводит удаляемые
+// файлы.
+func (b *Builder) Clean(ops []string) error {
+	names := make(map[string]bool, len(ops))
+	for _, name := range ops {
+		if b.conf.operation(name) == nil {
+			return usageErrorf("clean: operation '%s' not found", name)
+		}
+		names[name] = true
+	}
+
+	cache, err := b.readCache()
+	if err != nil {
+		return err
+	}
+	for _, path := range cache.outputs(names) {
+		if b.opts.DryRun {
+			fmt.Fprintln(b.s.stdout, "would remove", path)
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		fmt.Fprintln(b.s.stdout, "removed", path)
+	}
+
+	switch {
+	case b.opts.DryRun:
+	case len(names) != 0:
+		cache.reset(names)
+		return b.writeCache(cache)
+	default:
+		err := os.Remove(b.cacheFile)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Graph строит граф операций сценария. Если files равен true, в граф
+// добавляются файлы из кэша.
+func (b *Builder) Graph(files bool) (*Graph, error) {
+	var cache Cache
+	if files {
+		var err error
+		if cache, err = b.readCache(); err != nil {
+			return nil, err
+		}
+	}
+	return buildGraph(b.conf, cache), nil
+}
+
+// Explain выводит для каждого файла, который будет обработан операциями,
+// цепочку причин его обработки. Операции не выполняются, кэш не
+// изменяется. Если указан абсолютный путь file, выводится только этот
+// файл. Возвращает false, если ничего не выведено.
+func (b *Builder) Explain(file string) (bool, error) {
+	cache, err := b.readCache()
+	if err != nil {
+		return false, err
+	}
+
+	found := false
+	for i, op := range b.conf.Ops {
+		if err = b.prepare(cache, op); err != nil {
+			return false, err
+		}
+
+		name := opID(op, i)
+		for _, r := range op.reasons {
+			if len(file) != 0 && !samePath(r.path, file) {
+				continue
+			}
+			found = true
+			fmt.Fprintf(b.s.stdout, "%s: %s\n", name, r)
+		}
+	}
+	return found, nil
+}
+
+// samePath возвращает true, если path (относительно рабочей директории)
+// указывает на абсолютный путь abs.
+func samePath(path, abs string) bool {
+	p, err := filepath.Abs(path)
+	return err == nil && p == abs
+}
@@ -0,0 +1,227 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBuilder(test *testing.T) {
+	dir := writeConfigs(test, map[string]string{
+		"build.json": `{"ops": [{"name": "cc", "descr": "compile",
+			"sources": ["\\.c$"], "dirs": ["$(..)/src"],
+			"tool": "$(CC)", "args": ["-c", "$(@)"]}],
+			"defs": {"CC": ["cc"]}}`,
+		"src/a.c": ``,
+		"src/b.c": ``,
+		"src/c.c": ``,
+	})
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	var (
+		mu      sync.Mutex
+		runs    []string
+		out     bytes.Buffer
+		workers []int
+	)
+	opts := Options{
+		WorkDir: filepath.Join(dir, "bin"),
+		Jobs:    2,
+		Stdout:  &out,
+		Events: []EventSink{func(e *Event) {
+			if e.Event == EventExec {
+				workers = append(workers, e.Worker)
+			}
+		}},
+		Runner: RunFunc(func(op string, argv []string, w io.Writer) error {
+			mu.Lock()
+			defer mu.Unlock()
+			runs = append(runs, op+": "+strings.Join(argv, " "))
+			return nil
+		}),
+	}
+
+	build := func() {
+		b, err := NewBuilder(opts)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if err = b.Build(); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	build()
+	sort.Strings(runs)
+	expected := []string{
+		"cc: cc -c ../src/a.c",
+		"cc: cc -c ../src/b.c",
+		"cc: cc -c ../src/c.c",
+	}
+	if !equalStrings(runs, expected) {
+		test.Errorf("unexpected invocations:\n%s", strings.Join(runs, "\n"))
+	}
+	if out.String() != "compile\n" {
+		test.Errorf("unexpected output %q", out.String())
+	}
+	for _, w := range workers {
+		if w < 1 || w > 2 {
+			test.Errorf("unexpected workers %v", workers)
+			break
+		}
+	}
+
+	runs = nil
+	build()
+	if len(runs) != 0 {
+		test.Errorf("unexpected invocations:\n%s", strings.Join(runs, "\n"))
+	}
+}
+
+func TestShellOperation(test *testing.T) {
+	dir := writeConfigs(test, map[string]string{
+		"build.json": `{"ops": [{"name": "up", "descr": "upper",
+			"sources": ["\\.txt$"], "dirs": ["$(..)/src"], "shell": true,
+			"tool": "tr", "args": ["a-z", "A-Z", "<", "$(@)",
+				">", "$(/@)$(EXT)", "&&", "echo", "${BLD_TEST_MSG}"]}],
+			"defs": {"EXT": [".up"]}}`,
+		"src/it's.txt": `abc`,
+	})
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	os.Setenv("BLD_TEST_MSG", "a; b")
+	defer os.Unsetenv("BLD_TEST_MSG")
+
+	var rec, out bytes.Buffer
+	b, err := NewBuilder(Options{
+		WorkDir: filepath.Join(dir, "bin"),
+		Stdout:  &out,
+		Runner:  NewRecorder(&rec, ExecRunner{}),
+	})
+	if err != nil {
+		test.Fatal(err)
+	}
+	if err = b.Build(); err != nil {
+		test.Fatal(err)
+	}
+
+	script := `tr a-z A-Z < '../src/it'\''s.txt' > 'it'\''s.txt'.up && echo 'a; b'`
+	var call struct {
+		Argv []string `json:"argv"`
+	}
+	if err = json.Unmarshal(rec.Bytes(), &call); err != nil {
+		test.Fatal(err)
+	}
+	if !equalStrings(call.Argv, []string{"/bin/sh", "-c", script}) {
+		test.Errorf("unexpected command %q", call.Argv)
+	}
+	if out.String() != "upper\na; b\n" {
+		test.Errorf("unexpected output %q", out.String())
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "bin", "it's.txt.up"))
+	if err != nil {
+		test.Fatal(err)
+	}
+	if string(data) != "ABC" {
+		test.Errorf("unexpected result %q", data)
+	}
+}
+
+func TestBuilderOutputs(test *testing.T) {
+	dir := writeConfigs(test, map[string]string{
+		"build.json": `{"ops": [{"name": "cc",
+			"sources": ["\\.c$"], "dirs": ["$(..)/src"],
+			"tool": "sh", "args": ["-c", "touch $(/@).o other.txt", "$(@)"],
+			"outputs": ["$(/@).o", "keep.txt", "missing.txt"]}]}`,
+		"src/a.c":      ``,
+		"src/b.c":      ``,
+		"bin/keep.txt": ``,
+	})
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	var out bytes.Buffer
+	opts := Options{WorkDir: filepath.Join(dir, "bin"), Stdout: &out}
+	b, err := NewBuilder(opts)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if err = b.Build(); err != nil {
+		test.Fatal(err)
+	}
+
+	// объявленные файлы, созданные операцией; keep.txt существовал и не
+	// изменился, other.txt не объявлен
+	cache, err := ReadCache(DefaultCacheFile)
+	if err != nil {
+		test.Fatal(err)
+	}
+	list := cache.outputs(nil)
+	if v := strings.Join(list, " "); v != "a.c.o b.c.o" {
+		test.Errorf("outputs: %s", v)
+	}
+
+	if err = b.Clean(nil); err != nil {
+		test.Fatal(err)
+	}
+	for name, exists := range map[string]bool{"a.c.o": false, "b.c.o": false,
+		"keep.txt": true, "other.txt": true} {
+		if _, err := os.Stat(name); (err == nil) != exists {
+			test.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestBuilderProblems(test *testing.T) {
+	dir := writeConfigs(test, map[string]string{
+		"build.json": `{"combine": ["a.json"], "ops": [{"name": "a", "deps": ["b"]}]}`,
+		"a.json":     `{"ops": [{"name": "c", "tool": "cc", "dep": ["a"]}]}`,
+	})
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	var out bytes.Buffer
+	_, err = NewBuilder(Options{WorkDir: filepath.Join(dir, "bin"), Stdout: &out})
+	var cerr *ConfigError
+	if !errors.As(err, &cerr) || len(cerr.Problems) != 3 {
+		test.Fatalf("unexpected error %#v", err)
+	}
+	if !strings.Contains(cerr.Problems[0], `unknown field "dep"`) ||
+		!strings.Contains(cerr.Problems[2], "dependency b not found") {
+		test.Errorf("unexpected problems %q", cerr.Problems)
+	}
+	if out.Len() != 0 {
+		test.Errorf("unexpected output %q", out.String())
+	}
+}
@@ -0,0 +1,320 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Хранит информацию об атрибутах файлов, чтобы определить
+// какие изменились с прошлого запуска.
+type Cache map[string]*FileStateSnap
+
+// Кэш находится в рабочей директории, он хранит информацию о прошлом сотоянии
+// обрабатываемых файлов и позволяет узнать, какие файлы или их зависимости
+// изменились и требуют обработки.
+// Кэш представлен ассоциативным контейнером, где ключем является путь к файлу
+// относительно рабочей директории, а значением - атрибуты файла.
+type FileStateSnap struct {
+	Path string `json:"path"`
+	// Указывает что файл был модифицирован со времени прошлой обработки
+	Modified bool `json:"-"`
+	// Время последней модификации во время прошлой обработки
+	Time time.Time `json:"time"`
+	// Хэш файла во время прошлой обработки
+	Hash []byte `json:"hash"`
+	// Зависимости файла, пути относительно рабочей директории
+	Depends []string `json:"dependencies"`
+	// Имя операции, создавшей файл в рабочей директории (nil для исходных
+	// файлов). Снимок созданного файла не содержит хэша, пока файл не
+	// обработан как исходный.
+	Output *string `json:"output,omitempty"`
+	// Командные строки (утилита и аргументы до подстановки $(@)),
+	// с которыми файл был обработан, по именам операций
+	Commands map[string][]string `json:"commands,omitempty"`
+
+	// Причина, по которой файл считается модифицированным в текущем запуске
+	why string
+}
+
+// reason описывает причину обработки файла. Если причиной является
+// изменение зависимости, dep описывает причину ее изменения, так что
+// цепочка причин заканчивается первопричиной.
+type reason struct {
+	path string
+	what string
+	dep  *reason
+}
+
+// String возвращает цепочку причин, по одной на строку, с отступом
+// для каждого следующего звена.
+func (r *reason) String() string {
+	var buf bytes.Buffer
+	for i, link := range r.chain() {
+		if i != 0 {
+			buf.WriteString("\n" + strings.Repeat("  ", i))
+		}
+		buf.WriteString(link)
+	}
+	return buf.String()
+}
+
+// chain возвращает звенья цепочки причин в виде "path: what".
+func (r *reason) chain() []string {
+	links := make([]string, 0, 4)
+	for ; r != nil; r = r.dep {
+		links = append(links, r.path+": "+r.what)
+	}
+	return links
+}
+
+// Создает снимок состояния файла.
+func ShotFileState(path string, dirs []string) (*FileStateSnap, error) {
+	snap := new(FileStateSnap)
+	snap.Path = path
+	if err := snap.Sync(dirs); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// Синхронизирует снимок с состоянием файла.
+func (item *FileStateSnap) Sync(dirs []string) error {
+	fi, err := os.Lstat(item.Path)
+	if err != nil {
+		return err
+	}
+	hash, err := getFileHash(item.Path)
+	if err != nil {
+		return err
+	}
+	deps, err := getSourceDeps(item.Path, dirs)
+	if err != nil {
+		return err
+	}
+
+	item.Modified = true
+	item.Time = fi.ModTime()
+	item.Hash = hash
+	item.Depends = deps
+	return nil
+}
+
+// Чтение кэша из файла.
+func ReadCache(path string) (Cache, error) {
+
+	cache := make(map[string]*FileStateSnap)
+
+	f, err := os.Open(path)
+	if err == nil {
+		defer f.Close()
+		err = json.NewDecoder(f).Decode(&cache)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return nil, &CacheError{path, err}
+	}
+
+	return cache, nil
+}
+
+// Запись кэша в файл.
+func (cache *Cache) Write(path string) error {
+
+	b, err := json.MarshalIndent(&cache, "", "\t")
+	if err == nil {
+		err = ioutil.WriteFile(path, b, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("unable store cache %s: %w", path, err)
+	}
+	return nil
+}
+
+// Проверяет менялся ли файл или его зависимости, попутно добавляет или
+// обновляет снимки файлов. Файл также считается измененным, если операция op
+// обрабатывала его с другой командной строкой cmd. Возвращает причину
+// обработки файла или nil, если файл не изменился.
+func (cache *Cache) CheckSource(path string, dirs []string,
+	op string, cmd []string) (*reason, error) {
+
+	changed, err := cache.Check(path, dirs)
+	if err != nil {
+		return nil, err
+	}
+	item := (*cache)[path]
+	for _, dep := range item.Depends {
+		depChanged, err := cache.Check(dep, dirs)
+		if err != nil {
+			return nil, err
+		}
+		changed = depChanged || changed
+	}
+	if changed && !item.Modified {
+		// изменилась зависимость: список зависимостей мог измениться
+		if item.Depends, err = getSourceDeps(path, dirs); err != nil {
+			return nil, err
+		}
+		for _, dep := range item.Depends {
+			if _, err = cache.Check(dep, dirs); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	switch {
+	case len(item.why) != 0:
+		return &reason{path: path, what: item.why}, nil
+	case changed:
+		return cache.depReason(path, dirs), nil
+	case item.Commands[op] == nil:
+		return &reason{path: path, what: "not processed by the operation yet"}, nil
+	case !equalStrings(item.Commands[op], cmd):
+		return &reason{path: path, what: "command line changed"}, nil
+	}
+	return nil, nil
+}
+
+// depReason строит цепочку причин от файла до измененной зависимости,
+// спускаясь по непосредственно включаемым файлам. Если цепочку построить не
+// удалось, указывается первая измененная зависимость из списка.
+func (cache Cache) depReason(path string, dirs []string) *reason {
+	if r := cache.includeReason(path, dirs, map[string]bool{}); r != nil {
+		return r
+	}
+	for _, dep := range cache[path].Depends {
+		if item, exists := cache[dep]; exists && len(item.why) != 0 {
+			return &reason{path: path, what: "dependency " + dep + " changed",
+				dep: &reason{path: dep, what: item.why}}
+		}
+	}
+	return &reason{path: path, what: "dependency changed"}
+}
+
+func (cache Cache) includeReason(path string, dirs []string,
+	visited map[string]bool) *reason {
+
+	visited[path] = true
+	deps, _ := directDeps(path, dirs)
+	for _, dep := range deps {
+		item, exists := cache[dep]
+		if !exists || visited[dep] {
+			continue
+		}
+
+		sub := &reason{path: dep, what: item.why}
+		if len(item.why) == 0 {
+			sub = cache.includeReason(dep, dirs, visited)
+		}
+		if sub != nil {
+			return &reason{path: path, what: "dependency " + dep + " changed",
+				dep: sub}
+		}
+	}
+	return nil
+}
+
+// record запоминает командную строку, с которой операция op обработала файл.
+func (cache Cache) record(path string, op string, cmd []string) {
+	item := cache[path]
+	if item.Commands == nil {
+		item.Commands = make(map[string][]string)
+	}
+	item.Commands[op] = cmd
+}
+
+// Ищет файл в кеше. Если находит проверяет изменился ли он, и возвращает 
+// результат. Если не находит, добавляет его и зависимости в кэш, возвращает
+// true. Удаленный файл считается измененным. Причина изменения сохраняется
+// в снимке файла.
+func (cache *Cache) Check(path string, dirs []string) (bool, error) {
+
+	item, exists := (*cache)[path]
+	if !exists {
+		snap, err := ShotFileState(path, dirs)
+		if err != nil {
+			return false, err
+		}
+		snap.why = "new file"
+		(*cache)[path] = snap
+
+		for _, d := range snap.Depends {
+			if _, err = cache.Check(d, dirs); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	if item.Modified {
+		return true, nil
+	}
+
+	why := ""
+	fi, err := os.Lstat(path)
+	switch {
+	case os.IsNotExist(err):
+		item.Modified = true
+		item.why = "file removed"
+		return true, nil
+
+	case err != nil:
+		return false, err
+
+	case len(item.Hash) == 0:
+		why = "new file"
+
+	case !item.Time.Equal(fi.ModTime()):
+		why = "modification time changed"
+
+	default:
+		hash, err := getFileHash(path)
+		if err != nil {
+			return false, err
+		}
+		if bytes.Equal(hash, item.Hash) {
+			return false, nil
+		}
+		why = "content changed"
+	}
+
+	if err = item.Sync(dirs); err != nil {
+		return false, err
+	}
+	item.why = why
+	return true, nil
+}
+
+// equalStrings сравнивает списки строк.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func getFileHash(path string) ([]byte, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	Hash := md5.New()
+	if _, err = io.Copy(Hash, f); err != nil {
+		return nil, fmt.Errorf("unable compute hash of file %s: %w", path, err)
+	}
+
+	return Hash.Sum(nil), nil
+}
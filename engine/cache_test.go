@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// reloadCache сохраняет и загружает кэш, как между запусками утилиты.
+func reloadCache(test *testing.T, cache Cache) Cache {
+	b, err := json.Marshal(cache)
+	if err != nil {
+		test.Fatal(err)
+	}
+	loaded := make(Cache)
+	if err = json.Unmarshal(b, &loaded); err != nil {
+		test.Fatal(err)
+	}
+	return loaded
+}
+
+func TestCheckSourceReasons(test *testing.T) {
+	dir := writeConfigs(test, map[string]string{
+		"main.c": `#include "a.h"`,
+		"a.h":    `#include "b.h"`,
+		"b.h":    `// b`,
+	})
+	defer os.RemoveAll(dir)
+
+	dirs := []string{dir}
+	main := filepath.Join(dir, "main.c")
+	cmd := []string{"cc", "-c", "$(@)"}
+
+	check := func(cache Cache, cmd []string, expected string) {
+		r, err := cache.CheckSource(main, dirs, "cc", cmd)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if expected == "" {
+			if r != nil {
+				test.Errorf("unexpected reason:\n%s", r)
+			}
+			return
+		}
+		if r == nil || r.String() != expected {
+			test.Errorf("expected reason:\n%s\nactual:\n%v", expected, r)
+		}
+		cache.record(main, "cc", cmd)
+	}
+
+	cache := make(Cache)
+	check(cache, cmd, main+": new file")
+
+	cache = reloadCache(test, cache)
+	check(cache, cmd, "")
+
+	cache = reloadCache(test, cache)
+	check(cache, []string{"cc", "-O2", "-c", "$(@)"},
+		main+": command line changed")
+
+	b := filepath.Join(dir, "b.h")
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(b, later, later); err != nil {
+		test.Fatal(err)
+	}
+	cache = reloadCache(test, cache)
+	a := filepath.Join(dir, "a.h")
+	check(cache, []string{"cc", "-O2", "-c", "$(@)"},
+		main+": dependency "+a+" changed\n"+
+			"  "+a+": dependency "+b+" changed\n"+
+			"    "+b+": modification time changed")
+}
+
+func TestCacheOutputs(test *testing.T) {
+	cc, ld := "cc", "ld"
+	cache := Cache{
+		"a.c":   {Path: "a.c", Commands: map[string][]string{"cc": {"cc"}}},
+		"a.o":   {Path: "a.o", Output: &cc},
+		"app":   {Path: "app", Output: &ld},
+		"lib.a": {Path: "lib.a", Commands: map[string][]string{"ld": {"ld"}}},
+	}
+
+	if list := cache.outputs(nil); !equalStrings(list, []string{"a.o", "app"}) {
+		test.Errorf("unexpected outputs: %v", list)
+	}
+
+	ops := map[string]bool{"cc": true}
+	if list := cache.outputs(ops); !equalStrings(list, []string{"a.o"}) {
+		test.Errorf("unexpected outputs of cc: %v", list)
+	}
+
+	cache.reset(ops)
+	if _, exists := cache["a.o"]; exists {
+		test.Error("output of cc is not removed from the cache")
+	}
+	if _, exists := cache["app"]; !exists {
+		test.Error("output of ld is removed from the cache")
+	}
+	if cache["a.c"].Commands["cc"] != nil {
+		test.Error("command line of cc is not reset")
+	}
+	if cache["lib.a"].Commands["ld"] == nil {
+		test.Error("command line of ld is reset")
+	}
+}
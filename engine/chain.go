@@ -0,0 +1,429 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Operation struct {
+	Name  string   `json:"name"`
+	Descr string   `json:"descr"`
+	Deps  []string `json:"deps"`
+
+	Sources []string `json:"sources"`
+	Dirs    []string `json:"dirs"`
+
+	Group bool `json:"group"`
+
+	Tool string   `json:"tool"`
+	Args []string `json:"args"`
+	// Файлы, создаваемые операцией (удаляются командой clean)
+	Outputs []string `json:"outputs,omitempty"`
+	// Утилита вызывается через /bin/sh -c: текст tool и args передается
+	// оболочке как есть, а подставляемые значения экранируются
+	Shell bool `json:"shell"`
+
+	// Макроопределения операции, перекрывают глобальные
+	Defs definitions `json:"defs,omitempty"`
+
+	// Условие выполнения операции
+	Condition
+
+	// Место определения операции в сценарии
+	loc location
+	// Параметры запуска, с которыми загружена операция
+	s *session
+
+	// Хранит закешированные опции, с подстановленными переменными, кроме {}.
+	cachedOpts []string
+	// Создаваемые файлы с подстановленными переменными, кроме $(@)
+	cachedOutputs []string
+	// Список обрабатываемых файлов
+	targetFiles []string
+	// Причины обработки файлов
+	reasons []*reason
+}
+
+// выполняет операцию. Утилита не групповой операции вызывается для
+// каждого файла, одновременно выполняется не более Options.Jobs вызовов.
+func (op *Operation) Exec() error {
+	op.s.logf("exec %s for %v with %v",
+		op.Tool, op.targetFiles, op.cachedOpts)
+
+	if op.Group {
+		if len(op.targetFiles) == 0 {
+			return nil
+		}
+
+		opts, err := op.substituteFiles(op.targetFiles)
+		if err != nil {
+			return err
+		}
+		return op.execCommand(opts, 1)
+	}
+
+	cmds := make([][]string, 0, len(op.targetFiles))
+	for _, file := range op.targetFiles {
+		opts, err := op.substituteFiles([]string{file})
+		if err != nil {
+			return err
+		}
+		cmds = append(cmds, opts)
+	}
+
+	if jobs := op.s.parallel(); jobs > 1 {
+		return op.execParallel(cmds, jobs)
+	}
+	for _, opts := range cmds {
+		if err := op.execCommand(opts, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execParallel вызывает утилиту с каждым из наборов параметров cmds,
+// выполняя одновременно не более jobs вызовов. Каждый вызов занимает один
+// из jobs свободных номеров исполнителя. После первой ошибки новые вызовы
+// не начинаются, возвращается первая ошибка.
+func (op *Operation) execParallel(cmds [][]string, jobs int) error {
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		first error
+	)
+
+	// свободные номера исполнителей
+	slots := make(chan int, jobs)
+	for i := 1; i <= jobs; i++ {
+		slots <- i
+	}
+	for _, opts := range cmds {
+		slot := <-slots
+		mu.Lock()
+		failed := first != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+
+		wg.Add(1)
+		go func(opts []string, slot int) {
+			defer func() {
+				slots <- slot
+				wg.Done()
+			}()
+			if err := op.execCommand(opts, slot); err != nil {
+				mu.Lock()
+				if first == nil {
+					first = err
+				}
+				mu.Unlock()
+			}
+		}(opts, slot)
+	}
+	wg.Wait()
+	return first
+}
+
+// execCommand вызывает утилиту операции с указанными параметрами на
+// исполнителе worker и сообщает о вызове, при неудаче возвращает ToolError.
+func (op *Operation) execCommand(opts []string, worker int) error {
+	argv := op.command(opts)
+	e := &Event{Event: EventExec, Op: op.Name, Start: time.Now(), Argv: argv,
+		Worker: worker}
+
+	err := op.s.runner().Run(op.Name, argv, op.s.output())
+
+	terr, ok := err.(*ToolError)
+	if err != nil && !ok {
+		terr = &ToolError{Op: op.Name, Argv: argv, ExitCode: -1, Err: err}
+	}
+	code := 0
+	if terr != nil {
+		code = terr.ExitCode
+		e.Error = terr.Err.Error()
+	}
+	if code >= 0 {
+		e.ExitCode = &code
+	}
+	op.s.emit(e)
+
+	if terr != nil {
+		return terr
+	}
+	return nil
+}
+
+// command возвращает командную строку вызова утилиты с параметрами opts.
+func (op *Operation) command(opts []string) []string {
+	argv := append([]string{op.Tool}, opts...)
+	if op.Shell {
+		return []string{shellPath, "-c", strings.Join(argv, " ")}
+	}
+	return argv
+}
+
+// substituteFiles подставляет файлы files в закешированные опции.
+func (op *Operation) substituteFiles(files []string) ([]string, error) {
+	emb := Defines{"@": files}
+	return emb.substituteQuoted(op.cachedOpts, embMacroRegexp, op.quote(), op.s)
+}
+
+// quote возвращает функцию экранирования подставляемых значений: для
+// операции, вызываемой через оболочку, - quoteArg, иначе nil.
+func (op *Operation) quote() func(string) string {
+	if op.Shell {
+		return quoteArg
+	}
+	return nil
+}
+
+// isEnabled проверяет условие выполнения операции.
+func (op *Operation) isEnabled(def Defines) (bool, error) {
+	ok, err := op.enabled(def, op.s)
+	if err != nil {
+		return false, fmt.Errorf("%s: operation %s condition: %w",
+			op.loc, op.Name, err)
+	}
+	return ok, nil
+}
+
+// Составляет список обрабатываемых файлов и причин их обработки.
+// dirs, root и targ должны содержать полные пути. Опции должны быть
+// закешированы (CacheOpts), так как изменение командной строки операции
+// также является причиной обработки.
+func (op *Operation) SearchFiles(root, targ string, cache Cache, defs Defines) error {
+	var err error
+	if op.Dirs, err = op.searchDirs(defs, targ); err != nil {
+		return op.errorf(err)
+	}
+	if op.Sources, err = defs.substituteUserDefs(op.Sources, op.s); err != nil {
+		return op.errorf(err)
+	}
+
+	cmd := op.command(op.cachedOpts)
+
+	// построение списка файлов
+	changed := false
+	op.targetFiles = make([]string, 0, 32)
+	op.reasons = nil
+	matched, err := op.matchFiles()
+	if err != nil {
+		return op.errorf(err)
+	}
+	for _, name := range matched {
+		// проверка изменен ли файл
+		r, err := cache.CheckSource(name, op.Dirs, op.Name, cmd)
+		if err != nil {
+			return op.errorf(err)
+		}
+		if r != nil {
+			op.s.logf("operation %s: %s", op.Name, r)
+			op.reasons = append(op.reasons, r)
+		}
+		if op.Group {
+			if r != nil {
+				changed = true
+			}
+			op.targetFiles = append(op.targetFiles, name)
+		} else {
+			if r != nil {
+				op.targetFiles = append(op.targetFiles, name)
+			}
+		}
+	}
+	if op.Group && !changed {
+		op.targetFiles = []string{}
+	}
+
+	for _, name := range op.targetFiles {
+		cache.record(name, op.Name, cmd)
+	}
+	op.emitDecisions(matched)
+	return nil
+}
+
+// errorf добавляет к ошибке место определения и имя операции.
+func (op *Operation) errorf(err error) error {
+	return fmt.Errorf("%s: operation %s: %w", op.loc, op.Name, err)
+}
+
+// emitDecisions сообщает о решениях, принятых для файлов операции.
+func (op *Operation) emitDecisions(files []string) {
+	if op.s == nil || len(op.s.sinks) == 0 {
+		return
+	}
+
+	reasons := make(map[string]*reason, len(op.reasons))
+	for _, r := range op.reasons {
+		reasons[r.path] = r
+	}
+
+	for _, name := range files {
+		e := &Event{Event: EventCache, Op: op.Name, File: name,
+			Decision: "skip"}
+		if stringIndex(op.targetFiles, name) >= 0 {
+			e.Decision = "process"
+		}
+		if r := reasons[name]; r != nil {
+			e.Reason = r.chain()
+		}
+		op.s.emit(e)
+	}
+}
+
+// matchFiles возвращает файлы директорий op.Dirs, имена которых совпадают
+// с шаблонами op.Sources. Макровызовы в Dirs и Sources должны быть
+// подставлены.
+func (op *Operation) matchFiles() ([]string, error) {
+	files := make([]string, 0, 32)
+	for _, dir := range op.Dirs {
+
+		f, err := os.Open(dir)
+		if err != nil {
+			return nil, fmt.Errorf("Source dir not found: %w", err)
+		}
+		finfs, err := f.Readdir(-1)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, fi := range finfs {
+			name := filepath.Join(dir, fi.Name())
+			match, err := isNameMatch(name, op.Sources)
+			if err != nil {
+				return nil, err
+			}
+			if match {
+				files = append(files, name)
+			}
+		}
+	}
+	return files, nil
+}
+
+// searchDirs возвращает директории поиска обрабатываемых файлов: targ,
+// если директории не указаны.
+func (op *Operation) searchDirs(defs Defines, targ string) ([]string, error) {
+	if len(op.Dirs) == 0 {
+		return []string{targ}, nil
+	}
+	return defs.substituteUserDefs(op.Dirs, op.s)
+}
+
+// Возвращает true, если имя совпадает с одним из паттернов
+func isNameMatch(name string, pats []string) (bool, error) {
+	for _, pat := range pats {
+		match, err := regexp.MatchString(pat, name)
+		if err != nil {
+			return false, &ConfigError{Err: err}
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Кэширует опции в поле cachedOpt,
+// подставляя указанные переменные. Также подставляет переменные в имя утилиты.
+func (op *Operation) CacheOpts(defs Defines) error {
+	tool, err := defs.substituteQuoted([]string{op.Tool}, macroRegexp,
+		op.quote(), op.s)
+	if err != nil {
+		return op.errorf(err)
+	}
+	if len(tool) != 1 {
+		return op.errorf(macroErrorf(
+			"tool expands to %d values, expected one", len(tool)))
+	}
+	op.Tool = tool[0]
+
+	op.cachedOpts, err = defs.substituteQuoted(op.Args, macroRegexp,
+		op.quote(), op.s)
+	if err != nil {
+		return op.errorf(err)
+	}
+	if op.cachedOutputs, err = defs.substituteUserDefs(op.Outputs, op.s); err != nil {
+		return op.errorf(err)
+	}
+	return nil
+}
+
+// Scope возвращает макроопределения, действующие в операции: глобальные
+// макроопределения defs (должны быть развернуты), перекрытые
+// макроопределениями операции. Ссылка макроопределения операции на
+// собственное имя подставляет значение глобального макроопределения.
+// Макроопределение NAME+ дополняет глобальное, NAME? используется, только
+// если глобальное не определено.
+func (op *Operation) Scope(defs Defines) (Defines, error) {
+	if len(op.Defs) == 0 {
+		return defs, nil
+	}
+	scope, err := op.scope(defs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: operation %s defs: %w", op.loc, op.Name, err)
+	}
+	return scope, nil
+}
+
+func (op *Operation) scope(defs Defines) (Defines, error) {
+	scope := make(Defines, len(defs)+len(op.Defs))
+	for name, values := range defs {
+		scope[name] = values
+	}
+
+	names := make([]string, 0, len(op.Defs))
+	for name, d := range op.Defs {
+		names = append(names, name)
+		enabled, err := d.enabled(defs, op.s)
+		if err != nil {
+			return nil, err
+		}
+		values := enabled.values()
+		outer, exists := defs[name]
+		if exists {
+			self := regexp.MustCompile(`\$\(\/?` + regexp.QuoteMeta(name) + `\)`)
+			if values, err = (Defines{name: outer}).substituteDefs(values, self, op.s); err != nil {
+				return nil, err
+			}
+		}
+
+		switch {
+		case d[0].mode == defAppend:
+			values = append(append([]string{}, outer...), values...)
+		case d[0].mode == defDefault && exists:
+			values = outer
+		}
+		scope[name] = values
+	}
+
+	if err := scope.bootstrapNames(names, op.s); err != nil {
+		return nil, err
+	}
+	return scope, nil
+}
+
+func (op *Operation) Out() {
+	printArr := func(descr string, a []string) {
+		fmt.Println(descr)
+		for _, s := range a {
+			fmt.Print(s)
+			fmt.Print("  ")
+		}
+		fmt.Println()
+	}
+
+	printArr("SOURCE_DIRS:", op.Dirs)
+	printArr("TARGET_FILES:", op.targetFiles)
+
+	printArr("OPTIONS:", op.Args)
+	printArr("CACHED_OPTS:", op.cachedOpts)
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// файл базы данных команд компиляции по умолчанию, путь относительно
+// рабочей директории
+const DefaultCompdbFile = "compile_commands.json"
+
+// CompileCommand - запись базы данных команд компиляции
+// (compile_commands.json), используемой clangd и другими утилитами clang.
+type CompileCommand struct {
+	Directory string   `json:"directory"`
+	File      string   `json:"file"`
+	Arguments []string `json:"arguments"`
+}
+
+// CompileCommands возвращает команды, которыми операции обрабатывают свои
+// исходные файлы, независимо от их изменения. Групповые операции и
+// операции, вызываемые через оболочку (clang не разбирает скрипты
+// оболочки), пропускаются. Операции ничего не выполняют, кэш не
+// используется.
+func (b *Builder) CompileCommands() ([]*CompileCommand, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*CompileCommand, 0, 64)
+	for _, op := range b.conf.Ops {
+		if op.Group || op.Shell {
+			continue
+		}
+
+		files, err := op.sourceFiles(b.defs)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			args, err := op.substituteFiles([]string{file})
+			if err != nil {
+				return nil, op.errorf(err)
+			}
+			list = append(list, &CompileCommand{
+				Directory: wd,
+				File:      file,
+				Arguments: append([]string{op.Tool}, args...),
+			})
+		}
+	}
+	return list, nil
+}
+
+// sourceFiles подставляет макроопределения в поля операции и возвращает
+// все ее исходные файлы без проверки их изменения.
+func (op *Operation) sourceFiles(defs Defines) ([]string, error) {
+	scope, err := op.Scope(defs)
+	if err != nil {
+		return nil, err
+	}
+	if err = op.CacheOpts(scope); err != nil {
+		return nil, err
+	}
+	if op.Dirs, err = op.searchDirs(scope, "."); err != nil {
+		return nil, op.errorf(err)
+	}
+	if op.Sources, err = scope.substituteUserDefs(op.Sources, op.s); err != nil {
+		return nil, op.errorf(err)
+	}
+
+	files, err := op.matchFiles()
+	if err != nil {
+		return nil, op.errorf(err)
+	}
+	return files, nil
+}
+
+// WriteCompileCommands сохраняет команды в файл в формате
+// compile_commands.json.
+func WriteCompileCommands(path string, list []*CompileCommand) error {
+	body, err := json.MarshalIndent(list, "", "\t")
+	if err == nil {
+		err = ioutil.WriteFile(path, append(body, '\n'), 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("unable write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompileCommands(test *testing.T) {
+	dir := writeConfigs(test, map[string]string{
+		"src/a.c": ``,
+		"src/b.h": ``,
+	})
+	defer os.RemoveAll(dir)
+
+	b := &Builder{
+		conf: &Config{Ops: []*Operation{
+			{Name: "cc", Sources: []string{`\.c$`}, Dirs: []string{"$(SRC)"},
+				Tool: "$(CC)", Args: []string{"$(FLAGS)", "-c", "$(@)"}},
+			{Name: "ld", Group: true, Sources: []string{`\.c$`},
+				Dirs: []string{"$(SRC)"}, Tool: "ld", Args: []string{"$(@)"}},
+			{Name: "pp", Shell: true, Sources: []string{`\.c$`},
+				Dirs: []string{"$(SRC)"}, Tool: "cpp", Args: []string{"$(@)", "|", "wc"}},
+		}},
+		defs: Defines{
+			"SRC":   {filepath.Join(dir, "src")},
+			"CC":    {"clang"},
+			"FLAGS": {"-g", "-Wall"},
+		},
+	}
+
+	list, err := b.CompileCommands()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(list) != 1 {
+		test.Fatalf("expected one command, actual %d", len(list))
+	}
+
+	file := filepath.Join(dir, "src", "a.c")
+	if list[0].File != file {
+		test.Errorf("unexpected file %s", list[0].File)
+	}
+	expected := []string{"clang", "-g", "-Wall", "-c", file}
+	if !equalStrings(list[0].Arguments, expected) {
+		test.Errorf("expected arguments %v, actual %v",
+			expected, list[0].Arguments)
+	}
+}
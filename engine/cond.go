@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// Condition описывает условие применения элемента сценария: операции,
+// части макроопределения или комбинируемого сценария. Элемент применяется,
+// если выполняются все указанные условия.
+type Condition struct {
+	If   *IfCondition `json:"if,omitempty"`
+	When string       `json:"when,omitempty"`
+}
+
+// IfCondition проверяет значение переменной среды или макроса. Если
+// не указаны ни Equals, ни NotEquals, условие выполняется, когда значение
+// не пустое.
+type IfCondition struct {
+	Env       string  `json:"env,omitempty"`
+	Macro     string  `json:"macro,omitempty"`
+	Equals    *string `json:"equals,omitempty"`
+	NotEquals *string `json:"not-equals,omitempty"`
+}
+
+var whenRegexp = regexp.MustCompile(`^\s*(.*?)\s*(==|!=)\s*(.*?)\s*$`)
+
+// builtinDefs возвращает встроенные макроопределения. Значением $(.)
+// является абсолютный путь к рабочей (текущей) директории.
+func builtinDefs(root string) (Defines, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	return Defines{
+		".":      []string{wd},
+		"..":     []string{root},
+		"GOOS":   []string{runtime.GOOS},
+		"GOARCH": []string{runtime.GOARCH},
+	}, nil
+}
+
+// conditional возвращает true, если указано хотя бы одно условие.
+func (c *Condition) conditional() bool {
+	return c.If != nil || len(c.When) != 0
+}
+
+// refs возвращает имена макросов, на которые ссылается условие.
+func (c *Condition) refs() []string {
+	names := macroRefs([]string{c.When})
+	if c.If != nil && len(c.If.Macro) != 0 {
+		names = append(names, c.If.Macro)
+	}
+	return names
+}
+
+// enabled возвращает true, если условие выполняется. Макровызовы в
+// условии раскрываются с помощью def.
+func (c *Condition) enabled(def Defines, s *session) (bool, error) {
+	if c.If != nil {
+		if ok, err := c.If.holds(def, s); !ok || err != nil {
+			return false, err
+		}
+	}
+	if len(c.When) != 0 {
+		return evalWhen(c.When, def, s)
+	}
+	return true, nil
+}
+
+// holds проверяет условие вида {"env": "CC", "equals": "clang"}.
+func (c *IfCondition) holds(def Defines, s *session) (bool, error) {
+	var value string
+
+	switch {
+	case len(c.Env) != 0 && len(c.Macro) != 0:
+		return false, configErrorf("condition must check either env or macro, not both")
+
+	case len(c.Env) != 0:
+		value = os.Getenv(c.Env)
+
+	case len(c.Macro) != 0:
+		if _, exists := def[c.Macro]; exists {
+			values, err := def.substituteUserDefs([]string{"$(" + c.Macro + ")"}, s)
+			if err != nil {
+				return false, err
+			}
+			value = strings.Join(values, " ")
+		}
+
+	default:
+		return false, configErrorf("condition must specify env or macro")
+	}
+
+	switch {
+	case c.Equals != nil:
+		return value == *c.Equals, nil
+	case c.NotEquals != nil:
+		return value != *c.NotEquals, nil
+	}
+	return len(value) != 0, nil
+}
+
+// evalWhen вычисляет условие вида "$(CONFIG) == debug" или
+// "${CC} != gcc". Выражение без оператора сравнения истинно, если после
+// подстановки оно не пустое и не равно "0" или "false".
+func evalWhen(expr string, def Defines, s *session) (bool, error) {
+	values, err := def.substituteUserDefs([]string{expr}, s)
+	if err != nil {
+		return false, err
+	}
+	if len(values) != 1 {
+		return false, macroErrorf("condition '%s' expands to %d values, expected one",
+			expr, len(values))
+	}
+
+	m := whenRegexp.FindStringSubmatch(values[0])
+	if m == nil {
+		v := strings.TrimSpace(values[0])
+		return len(v) != 0 && v != "0" && v != "false", nil
+	}
+
+	if m[2] == "==" {
+		return m[1] == m[3], nil
+	}
+	return m[1] != m[3], nil
+}
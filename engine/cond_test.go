@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"encoding/json"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestConditions(test *testing.T) {
+	os.Setenv("BLD_TEST_CC", "clang")
+
+	conf := new(Config)
+	err := json.Unmarshal([]byte(`{
+		"defs": {
+			"CONFIG": ["debug"],
+			"FLAGS": [
+				{"values": ["-g"], "when": "$(CONFIG) == debug"},
+				{"values": ["-O2"], "when": "$(CONFIG) != debug"},
+				{"values": ["-Weverything"], "if": {"env": "BLD_TEST_CC", "equals": "clang"}}
+			],
+			"OS": {"values": ["$(GOOS)"], "if": {"macro": "GOOS"}}
+		},
+		"ops": [
+			{"name": "a", "when": "$(CONFIG) == release"},
+			{"name": "b", "if": {"env": "BLD_TEST_CC", "not-equals": "gcc"}}
+		]
+	}`), conf)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if err = conf.dropDisabled(".."); err != nil {
+		test.Fatal(err)
+	}
+
+	def, err := conf.Defines("..")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if err = def.bootstrap(nil); err != nil {
+		test.Fatal(err)
+	}
+
+	if v := strings.Join(def["FLAGS"], " "); v != "-g -Weverything" {
+		test.Errorf("FLAGS = %s", v)
+	}
+	if v := strings.Join(def["OS"], " "); v != runtime.GOOS {
+		test.Errorf("OS = %s", v)
+	}
+	if len(conf.Ops) != 1 || conf.Ops[0].Name != "b" {
+		test.Errorf("unexpected enabled ops: %v", conf.Ops)
+	}
+}
+
+func TestEvalWhen(test *testing.T) {
+	def := Defines{"X": []string{"1"}, "EMPTY": []string{""}}
+
+	cases := map[string]bool{
+		"$(X) == 1":  true,
+		"$(X)==1":    true,
+		"$(X) != 1":  false,
+		"$(X)":       true,
+		"$(EMPTY)":   false,
+		"false":      false,
+		" a == a ":   true,
+		"$(EMPTY)==": true,
+	}
+
+	for expr, expected := range cases {
+		if res, err := evalWhen(expr, def, nil); err != nil || res != expected {
+			test.Errorf("evalWhen(%q) = %v, expected %v", expr, res, expected)
+		}
+	}
+}
+
+func TestConditionsOrder(test *testing.T) {
+	conf := new(Config)
+	err := json.Unmarshal([]byte(`{
+		"defs": {
+			"A": {"values": ["-a"], "when": "$(MODE) == debug"},
+			"MODE": {"values": ["$(Z)"], "when": "$(GOOS) != none"},
+			"Z": ["debug"],
+			"B": {"values": ["-b"], "if": {"macro": "A"}},
+			"C": {"values": ["-c"], "if": {"macro": "UNDEFINED"}}
+		}
+	}`), conf)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	def, err := conf.Defines("..")
+	if err != nil {
+		test.Fatal(err)
+	}
+	for name, expected := range map[string]string{"A": "-a", "B": "-b", "C": ""} {
+		if v := strings.Join(def[name], " "); v != expected {
+			test.Errorf("%s = %s", name, v)
+		}
+	}
+
+	conf = new(Config)
+	err = json.Unmarshal([]byte(`{
+		"defs": {
+			"A": {"values": ["-a"], "when": "$(B) == 1"},
+			"B": {"values": ["1"], "when": "$(A) == -a"}
+		}
+	}`), conf)
+	if err != nil {
+		test.Fatal(err)
+	}
+	_, err = conf.Defines("..")
+	if err == nil || !strings.Contains(err.Error(), "A -> B -> A") {
+		test.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestValueCycle(test *testing.T) {
+	dir := writeConfigs(test, map[string]string{
+		"build.json": `{"defs": {"A": ["$(B)"], "B": ["x$(A)"]}}`,
+	})
+	defer os.RemoveAll(dir)
+
+	conf, err := LoadConfig("build.json", dir, nil)
+	if err == nil {
+		_, err = conf.Defines(dir)
+	}
+	if err == nil || !strings.Contains(err.Error(), "cyclic reference in macro definitions") {
+		test.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,441 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type Config struct {
+	path    string       `json:"-"`
+	Combine []*include   `json:"combine,omitempty"`
+	Defs    definitions  `json:"defs,omitempty"`
+	Ops     []*Operation `json:"ops,omitempty"`
+
+	// параметры запуска, с которыми загружена конфигурация
+	s *session
+	// неизвестные поля, найденные при загрузке всех сценариев
+	problems []string
+}
+
+// include описывает комбинируемый сценарий. В сценарии задается строкой
+// с путем или объектом с путем и условием.
+type include struct {
+	Path string `json:"path"`
+	Condition
+}
+
+// LoadConfig загружает конфигурацию: читает указанный 
+// конфигурационный файл и рекурсивно комбинирует его с необходимыми.
+// Элементы сценария, условия которых не выполняются, отбрасываются.
+// Путь path указывается относительно корневой директории dir. Из opts
+// используются параметры макроподстановки и журнал, opts может быть nil.
+// Неизвестные поля во всех сценариях возвращаются в поле Problems ошибки
+// ConfigError.
+func LoadConfig(path string, dir string, opts *Options) (*Config, error) {
+	conf, err := loadConfig(path, dir, newSession(opts))
+	if err == nil && len(conf.problems) != 0 {
+		return nil, &ConfigError{Problems: conf.problems, Err: fmt.Errorf(
+			"scenario %s has %d problem(s)", path, len(conf.problems))}
+	}
+	return conf, err
+}
+
+func loadConfig(path string, dir string, s *session) (*Config, error) {
+	root, err := loadConfigTree(path, dir, s)
+	if err != nil {
+		return nil, fmt.Errorf("unable load configuration: %w", err)
+	}
+
+	s.logf("configuration loaded")
+	return root, nil
+}
+
+func loadConfigTree(path string, dir string, s *session) (*Config, error) {
+	l := &configLoader{dir: dir, s: s, visited: make(map[string]bool)}
+	if err := l.load(filepath.Join(dir, path)); err != nil {
+		return nil, err
+	}
+	root := l.root
+
+	if err := root.dropDisabled(dir); err != nil {
+		return nil, err
+	}
+	if err := root.checkEnvVars(); err != nil {
+		return nil, err
+	}
+
+	// check name uniq
+	for i, op := range root.Ops {
+		for j := i + 1; j < len(root.Ops); j++ {
+			if op.Name == root.Ops[j].Name {
+				return nil, configErrorf(
+					"two or more operations has same name '%s': %s and %s",
+					op.Name, op.loc, root.Ops[j].loc)
+			}
+		}
+	}
+
+	return root, nil
+}
+
+// configLoader загружает сценарии в порядке обхода в глубину: сначала
+// сценарий, затем комбинируемые им сценарии в порядке перечисления.
+type configLoader struct {
+	// корневая директория проекта
+	dir string
+	// параметры запуска
+	s *session
+	// результирующая конфигурация
+	root *Config
+	// канонические пути загруженных сценариев
+	visited map[string]bool
+	// цепочка подключений загружаемого сценария
+	chain []string
+}
+
+// load загружает сценарий и рекурсивно комбинируемые им сценарии. Каждый
+// сценарий загружается только один раз, циклическое подключение является
+// ошибкой.
+func (l *configLoader) load(path string) error {
+	canon, err := canonicalPath(path)
+	if err != nil {
+		return err
+	}
+	if i := stringIndex(l.chain, canon); i >= 0 {
+		cycle := append(l.chain[i:], canon)
+		return configErrorf("cyclic combine of configs: %s",
+			strings.Join(cycle, " -> "))
+	}
+	if l.visited[canon] {
+		l.s.logf("config %s already combined", path)
+		return nil
+	}
+	l.visited[canon] = true
+
+	l.chain = append(l.chain, canon)
+	defer func() { l.chain = l.chain[:len(l.chain)-1] }()
+
+	conf, err := readConfigFile(path, l.s)
+	if err != nil {
+		return err
+	}
+	if l.root == nil {
+		l.root = conf
+	} else {
+		l.root.combine(conf)
+		l.root.problems = append(l.root.problems, conf.problems...)
+	}
+
+	for _, inc := range conf.Combine {
+		def, err := l.root.defines(l.dir)
+		if err != nil {
+			return err
+		}
+		ok, err := inc.enabled(def, l.s)
+		if err != nil {
+			return fmt.Errorf("%s: combine %s: %w", conf.path, inc.Path, err)
+		}
+		if !ok {
+			l.s.logf("config %s skipped by condition", inc.Path)
+			continue
+		}
+
+		// путь подключаемого сценария указывается относительно сценария
+		p, err := expandEnvVars(inc.Path, l.s)
+		if err != nil {
+			return fmt.Errorf("%s: field combine: %w", conf.path, err)
+		}
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(filepath.Dir(conf.path), p)
+		}
+		inc.Path = p
+		if err = l.load(inc.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// canonicalPath возвращает абсолютный путь к файлу без символических
+// ссылок. Если файл не существует, возвращается абсолютный путь.
+func canonicalPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved, nil
+	}
+	return abs, nil
+}
+
+// readConfigFile читает и парсит указанный конфигурационный файл, формат
+// файла определяется по расширению.
+func readConfigFile(path string, s *session) (*Config, error) {
+	s.logf("loading config %s", path)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+
+	format := getConfigFormat(path)
+	if data, err = format.toJSON(data); err != nil {
+		return nil, configErrorf("%s: %s", path, err)
+	}
+
+	// о неизвестных полях сообщается вместе с проблемами, найденными при
+	// проверке, поэтому загрузка продолжается без них
+	problems := checkFields(path, data, format.positions)
+	decode := decodeStrict
+	if len(problems) != 0 {
+		decode = json.Unmarshal
+	}
+
+	conf := &Config{problems: problems}
+	if err = decode(data, conf); err != nil {
+		loc := location{file: path}
+		if format.positions {
+			loc = errorLocation(path, data, err)
+		}
+		return nil, configErrorf("%s: %s", loc, err)
+	}
+
+	conf.path = path
+	conf.s = s
+	for _, op := range conf.Ops {
+		op.s = s
+	}
+	conf.setLocations(location{file: path})
+	if format.positions {
+		locateConfig(conf, data)
+	}
+
+	if conf.Defs, err = conf.Defs.parseModes(s); err != nil {
+		return nil, err
+	}
+	for _, op := range conf.Ops {
+		for _, d := range op.Defs {
+			for _, part := range d {
+				part.loc = op.loc
+			}
+		}
+		if op.Defs, err = op.Defs.parseModes(s); err != nil {
+			return nil, err
+		}
+	}
+	return conf, nil
+}
+
+// setLocations устанавливает место определения всех операций и
+// макроопределений сценария.
+func (conf *Config) setLocations(loc location) {
+	for _, d := range conf.Defs {
+		for _, part := range d {
+			part.loc = loc
+		}
+	}
+	for _, op := range conf.Ops {
+		op.loc = loc
+	}
+}
+
+// checkEnvVars проверяет, что все вызовы переменных среды в полях сценария
+// могут быть раскрыты, иначе возвращает ошибку с указанием файла и поля.
+// Вызывается после удаления элементов, условия которых не выполняются,
+// поэтому проверяются только применяемые операции и части
+// макроопределений. Части макроопределений операций с условиями
+// проверяются при подстановке, пути комбинируемых сценариев - при
+// загрузке.
+func (conf *Config) checkEnvVars() error {
+	var first error
+	check := func(loc location, field string, values []string) {
+		for _, v := range values {
+			if _, err := expandEnvVars(v, conf.s); err != nil && first == nil {
+				first = fmt.Errorf("%s: field %s: %w", loc, field, err)
+			}
+		}
+	}
+
+	for name, d := range conf.Defs {
+		for _, part := range d {
+			check(part.loc, "defs."+name,
+				append([]string{part.When}, part.Values...))
+		}
+	}
+	for i, op := range conf.Ops {
+		prefix := fmt.Sprintf("ops[%d].", i)
+		if len(op.Name) != 0 {
+			prefix = "ops." + op.Name + "."
+		}
+		check(op.loc, prefix+"when", []string{op.When})
+		for name, d := range op.Defs {
+			for _, part := range d {
+				if !part.conditional() {
+					check(part.loc, prefix+"defs."+name, part.Values)
+				}
+			}
+		}
+		check(op.loc, prefix+"sources", op.Sources)
+		check(op.loc, prefix+"dirs", op.Dirs)
+		check(op.loc, prefix+"tool", []string{op.Tool})
+		check(op.loc, prefix+"args", op.Args)
+		check(op.loc, prefix+"outputs", op.Outputs)
+	}
+	return first
+}
+
+// combine присоединяет к конфигурации root конфигурацию cnf 
+// по следующим правилам:
+//	* Список Combine не изменяется (комбинируемые сценарии загружаются
+//	рекурсивно в процедуре загрузки);
+//	* Списки Defs объединяются, части определений с одинаковыми именами
+//	объединяются (режимы объединения применяются после проверки условий);
+//	* Списки Ops объединяются, не допускается совпадение имен (проверяется в
+// 	процедуре загрузки).
+func (root *Config) combine(cnf *Config) {
+	root.s.logf("combine config %s", cnf.path)
+
+	if root.Defs == nil {
+		root.Defs = make(definitions)
+	}
+	for key, d := range cnf.Defs {
+		root.Defs[key] = append(root.Defs[key], d...)
+	}
+
+	root.Ops = append(root.Ops, cnf.Ops...)
+}
+
+// Defines возвращает развернутые макроопределения конфигурации вместе со
+// встроенными (см. defines). Значением $(..) является root - путь к
+// корневой директории относительно рабочей.
+func (conf *Config) Defines(root string) (Defines, error) {
+	def, err := conf.defines(root)
+	if err != nil {
+		return nil, err
+	}
+	if err = def.bootstrap(conf.s); err != nil {
+		return nil, err
+	}
+	return def, nil
+}
+
+// defines возвращает макроопределения конфигурации вместе со встроенными,
+// отбрасывая части, условия которых не выполняются. Макрос, все части
+// которого отброшены, имеет пустое множество значений.
+func (conf *Config) defines(root string) (Defines, error) {
+	def, _, err := conf.enabledDefs(root)
+	return def, err
+}
+
+// enabledDefs возвращает макроопределения конфигурации вместе со
+// встроенными и части макроопределений, условия которых выполняются.
+// Условия части проверяются после определения всех макросов, на которые
+// они ссылаются.
+func (conf *Config) enabledDefs(root string) (Defines, definitions, error) {
+	def, err := builtinDefs(root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := &defResolver{defs: conf.Defs, def: def, s: conf.s,
+		parts: make(definitions, len(conf.Defs)), done: make(map[string]bool)}
+	names := make([]string, 0, len(conf.Defs))
+	for name := range conf.Defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err = r.resolve(name, false); err != nil {
+			return nil, nil, err
+		}
+	}
+	return def, r.parts, nil
+}
+
+// dropDisabled удаляет из конфигурации части макроопределений и операции,
+// условия которых не выполняются.
+func (conf *Config) dropDisabled(root string) error {
+	def, parts, err := conf.enabledDefs(root)
+	if err != nil {
+		return err
+	}
+	for name, d := range parts {
+		conf.Defs[name] = d
+	}
+
+	ops := conf.Ops[:0]
+	for _, op := range conf.Ops {
+		ok, err := op.isEnabled(def)
+		if err != nil {
+			return err
+		}
+		if ok {
+			ops = append(ops, op)
+		} else {
+			conf.s.logf("operation %s (%s) skipped by condition", op.Name, op.loc)
+		}
+	}
+	conf.Ops = ops
+	return nil
+}
+
+// operation возвращает операцию с указанным именем или nil.
+func (conf *Config) operation(name string) *Operation {
+	for _, op := range conf.Ops {
+		if op.Name == name {
+			return op
+		}
+	}
+	return nil
+}
+
+// Store сохраняет конфигурацию в json-файл (предназначена для диагностики).
+func (conf *Config) Store(path string) error {
+	body, err := json.MarshalIndent(conf, "", "\t")
+	if err == nil {
+		err = ioutil.WriteFile(path, body, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("unable store configuration: %w", err)
+	}
+
+	conf.s.logf("config %s stored", path)
+	return nil
+}
+
+// stringIndex ищет в списке указанную строку и возвращает ее индекс,
+// если такая строка отсутствует, тогда возвращается -1.
+func stringIndex(list []string, s string) int {
+	for i, l := range list {
+		if l == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// UnmarshalJSON разбирает комбинируемый сценарий, заданный строкой с путем
+// или объектом с путем и условием.
+func (inc *include) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &inc.Path); err == nil {
+		return nil
+	}
+
+	type plain include
+	return decodeStrict(b, (*plain)(inc))
+}
+
+// MarshalJSON записывает сценарий без условия строкой с путем.
+func (inc *include) MarshalJSON() ([]byte, error) {
+	if !inc.conditional() {
+		return json.Marshal(inc.Path)
+	}
+
+	type plain include
+	return json.Marshal((*plain)(inc))
+}
@@ -0,0 +1,405 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfigs создает во временной директории файлы сценариев и
+// возвращает путь к директории.
+func writeConfigs(test *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "bld")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			test.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+// mustLoad загружает сценарий, завершая тест при ошибке.
+func mustLoad(test *testing.T, path, dir string) *Config {
+	conf, err := LoadConfig(path, dir, nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	return conf
+}
+
+// mustCanonical возвращает канонический путь, завершая тест при ошибке.
+func mustCanonical(test *testing.T, path string) string {
+	p, err := canonicalPath(path)
+	if err != nil {
+		test.Fatal(err)
+	}
+	return p
+}
+
+func TestCombineDiamond(test *testing.T) {
+	dir := writeConfigs(test, map[string]string{
+		"build.json":      `{"combine": ["inc/a.json", "inc/b.json"], "ops": [{"name": "root"}]}`,
+		"inc/a.json":      `{"combine": ["common.json"], "ops": [{"name": "a"}]}`,
+		"inc/b.json":      `{"combine": ["./common.json"], "ops": [{"name": "b"}]}`,
+		"inc/common.json": `{"ops": [{"name": "common"}]}`,
+	})
+	defer os.RemoveAll(dir)
+
+	conf := mustLoad(test, "build.json", dir)
+
+	names := make([]string, len(conf.Ops))
+	for i, op := range conf.Ops {
+		names[i] = op.Name
+	}
+	if v := strings.Join(names, " "); v != "root a common b" {
+		test.Errorf("ops = %s", v)
+	}
+}
+
+func TestCombineCycle(test *testing.T) {
+	dir := writeConfigs(test, map[string]string{
+		"build.json": `{"combine": ["a.json"]}`,
+		"a.json":     `{"combine": ["b.json"]}`,
+		"b.json":     `{"combine": ["a.json"]}`,
+	})
+	defer os.RemoveAll(dir)
+
+	_, err := LoadConfig("build.json", dir, nil)
+	if err == nil {
+		test.Fatal("cycle not detected")
+	}
+	var cerr *ConfigError
+	if !errors.As(err, &cerr) {
+		test.Errorf("unexpected error type %T", err)
+	}
+	a := mustCanonical(test, filepath.Join(dir, "a.json"))
+	b := mustCanonical(test, filepath.Join(dir, "b.json"))
+	if !strings.HasSuffix(err.Error(), a+" -> "+b+" -> "+a) {
+		test.Errorf("unexpected message: %s", err)
+	}
+}
+
+func TestConfigLocations(test *testing.T) {
+	dir := writeConfigs(test, map[string]string{
+		"build.json": `{
+	"combine": ["a.json"],
+	"defs": {
+		"X": ["1"],
+		"Y!": ["2"]
+	},
+	"ops": [
+		{"name": "first"},
+		{
+			"name": "same"
+		}
+	]
+}`,
+		"a.json": `{"ops": [{"name": "same"}]}`,
+	})
+	defer os.RemoveAll(dir)
+
+	conf, err := readConfigFile(filepath.Join(dir, "build.json"), nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	locs := map[string]location{
+		"X":      conf.Defs["X"][0].loc,
+		"Y":      conf.Defs["Y"][0].loc,
+		"first":  conf.Ops[0].loc,
+		"second": conf.Ops[1].loc,
+	}
+	expected := map[string][2]int{
+		"X":      {4, 3},
+		"Y":      {5, 3},
+		"first":  {8, 3},
+		"second": {9, 3},
+	}
+	for name, loc := range locs {
+		if loc.line != expected[name][0] || loc.col != expected[name][1] {
+			test.Errorf("%s at %s, expected %v", name, loc, expected[name])
+		}
+	}
+
+	_, err = LoadConfig("build.json", dir, nil)
+	if msg := fmt.Sprint(err); !strings.Contains(msg, "build.json:9:3 and ") ||
+		!strings.HasSuffix(msg, "a.json:1:10") {
+		test.Errorf("unexpected message: %s", msg)
+	}
+}
+
+func TestDisabledEnvVars(test *testing.T) {
+	dir := writeConfigs(test, map[string]string{
+		"build.json": `{
+	"combine": [{"path": "${BLD_TEST_UNSET:?required}.json", "if": {"env": "BLD_TEST_UNSET"}}],
+	"defs": {"X": {"values": ["${BLD_TEST_UNSET}"], "if": {"env": "BLD_TEST_UNSET"}}},
+	"ops": [
+		{"name": "a", "tool": "${BLD_TEST_UNSET:?required}", "if": {"env": "BLD_TEST_UNSET"}},
+		{"name": "b", "tool": "${BLD_TEST_UNSET}"}
+	]
+}`,
+	})
+	defer os.RemoveAll(dir)
+
+	conf, err := LoadConfig("build.json", dir, nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(conf.Ops) != 1 || conf.Ops[0].Name != "b" {
+		test.Errorf("unexpected ops %v", conf.Ops)
+	}
+
+	_, err = LoadConfig("build.json", dir, &Options{StrictEnv: true})
+	if err == nil || !strings.Contains(err.Error(), "ops.b.tool") {
+		test.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestUnknownFields(test *testing.T) {
+	data := []byte(`{
+	"combine": [{"path": "a.json", "whem": "1"}],
+	"defs": {"X": {"values": [], "iff": {}}},
+	"ops": [{"name": "a", "dep": ["b"], "if": {"envv": "X"}}]
+}`)
+
+	expected := []string{
+		`f:2:33: unknown field "whem"`,
+		`f:3:31: unknown field "iff"`,
+		`f:4:24: unknown field "dep"`,
+		`f:4:45: unknown field "envv"`,
+	}
+	problems := checkFields("f", data, true)
+	if strings.Join(problems, "\n") != strings.Join(expected, "\n") {
+		test.Errorf("unexpected problems:\n%s", strings.Join(problems, "\n"))
+	}
+
+	conf := new(Config)
+	if err := decodeStrict(data, conf); err == nil {
+		test.Error("unknown fields accepted by decoder")
+	}
+}
+
+func TestUnknownFieldsLoad(test *testing.T) {
+	dir := writeConfigs(test, map[string]string{
+		"build.json": `{"combine": ["a.json"], "ops": [{"name": "a", "dep": ["b"]}]}`,
+		"a.json":     `{"ops": [{"name": "b", "argz": []}]}`,
+	})
+	defer os.RemoveAll(dir)
+
+	_, err := LoadConfig("build.json", dir, nil)
+	var cerr *ConfigError
+	if !errors.As(err, &cerr) {
+		test.Fatalf("unexpected error %v", err)
+	}
+	expected := []string{`build.json:1:47: unknown field "dep"`,
+		`a.json:1:24: unknown field "argz"`}
+	if len(cerr.Problems) != len(expected) {
+		test.Fatalf("unexpected problems %q", cerr.Problems)
+	}
+	for i, p := range cerr.Problems {
+		if !strings.HasSuffix(p, expected[i]) {
+			test.Errorf("problem %q, expected %q", p, expected[i])
+		}
+	}
+}
+
+func TestValidate(test *testing.T) {
+	conf := new(Config)
+	err := json.Unmarshal([]byte(`{"ops": [
+		{"name": "a", "tool": "cc", "sources": ["$(SRC)"], "args": ["$(@)"]},
+		{"name": "b", "deps": ["a", "c"], "group": true, "args": ["x"]}
+	]}`), conf)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	problems := conf.validate(Defines{"SRC": []string{"(("}})
+	expected := []string{
+		"operation a: invalid sources pattern",
+		"operation b: tool is not specified",
+		"operation b: dependency c not found",
+		"operation b: group operation does not use $(@) in args",
+	}
+	if len(problems) != len(expected) {
+		test.Fatalf("unexpected problems:\n%s", strings.Join(problems, "\n"))
+	}
+	for i, p := range problems {
+		if !strings.Contains(p, expected[i]) {
+			test.Errorf("problem %q, expected %q", p, expected[i])
+		}
+	}
+}
+
+func TestConfigFormats(test *testing.T) {
+	dir := writeConfigs(test, map[string]string{
+		"build.yaml": `
+combine: [a.toml, b.json]
+defs:
+  CC!: [clang]
+ops:
+  - name: yaml
+    tool: $(CC)
+`,
+		"a.toml": `
+combine = ["c.yml"]
+
+[defs]
+CC = ["gcc"]
+
+[[ops]]
+name = "toml"
+args = ["-j", 4, false]
+`,
+		"b.json": `{"ops": [{"name": "json"}]}`,
+		"c.yml": `
+defs:
+  LEVEL: {values: [2], when: $(OPT) == 1}
+  OPT: [1]
+ops: [{name: yml, group: true, args: [-O, 3, true, 0.5, "$(@)"]}]`,
+	})
+	defer os.RemoveAll(dir)
+
+	scenario := findScenario(dir, "build")
+	if scenario != "build.yaml" {
+		test.Fatalf("found scenario %s", scenario)
+	}
+
+	conf := mustLoad(test, scenario, dir)
+
+	names := make([]string, len(conf.Ops))
+	for i, op := range conf.Ops {
+		names[i] = op.Name
+	}
+	if v := strings.Join(names, " "); v != "yaml toml yml json" {
+		test.Errorf("ops = %s", v)
+	}
+	defs, err := conf.Defines(dir)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if v := strings.Join(defs["CC"], " "); v != "clang" {
+		test.Errorf("CC = %s", v)
+	}
+	if v := strings.Join(defs["LEVEL"], " "); v != "2" {
+		test.Errorf("LEVEL = %s", v)
+	}
+	if v := strings.Join(conf.Ops[1].Args, " "); v != "-j 4 false" {
+		test.Errorf("toml args = %s", v)
+	}
+	yml := conf.Ops[2]
+	if v := strings.Join(yml.Args, " "); !yml.Group || v != "-O 3 true 0.5 $(@)" {
+		test.Errorf("yml op: group %v, args %s", yml.Group, v)
+	}
+}
+
+func TestRelaxedJSON(test *testing.T) {
+	data := []byte(`{
+	// comment with "quotes", and commas,
+	"defs": {
+		"URL": ["http://host/*path*/", "a\"//b",], /* block
+		comment */ "X": ["1"],
+	},
+	"ops": [
+		{"name": "a", /* inline */ "tool": "cc",},
+	],
+}`)
+
+	out, err := relaxedJSON(data)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(out) != len(data) {
+		test.Fatalf("length changed: %d -> %d", len(data), len(out))
+	}
+
+	conf := new(Config)
+	if err := decodeStrict(out, conf); err != nil {
+		test.Fatalf("%s\n%s", err, out)
+	}
+	conf.path = "f"
+	conf.setLocations(location{file: "f"})
+	locateConfig(conf, out)
+
+	if v := strings.Join(conf.Defs["URL"].values(), " "); v != `http://host/*path*/ a"//b` {
+		test.Errorf("URL = %s", v)
+	}
+	if loc := conf.Defs["X"][0].loc.String(); loc != "f:5:14" {
+		test.Errorf("X at %s", loc)
+	}
+	if loc := conf.Ops[0].loc.String(); loc != "f:8:3" {
+		test.Errorf("op at %s", loc)
+	}
+
+	if _, err := relaxedJSON([]byte("{\n/* x")); err == nil {
+		test.Error("unterminated comment accepted")
+	}
+
+	for _, bad := range []string{`[,]`, `{,}`, `["a",,]`, `["a", /* x */ ,]`,
+		`{"a": ,}`, `[,"a"]`} {
+		out, err := relaxedJSON([]byte(bad))
+		if err != nil {
+			test.Fatal(err)
+		}
+		var v interface{}
+		if err = json.Unmarshal(out, &v); err == nil {
+			test.Errorf("%s accepted as %s", bad, out)
+		}
+	}
+}
+
+func TestDiscoverProject(test *testing.T) {
+	dir := writeConfigs(test, map[string]string{
+		"p/build.yaml":        "",
+		"p/src/a/main.c":      "",
+		"p/bin/bldcache.json": "{}",
+		"m/.bldroot":          "out\n",
+		"m/build.json":        "{}",
+		"m/sub/build.json":    "{}",
+	})
+	defer os.RemoveAll(dir)
+	dir = mustCanonical(test, dir)
+
+	p, err := discoverProject(filepath.Join(dir, "p/src/a"))
+	if err != nil {
+		test.Fatal(err)
+	}
+	if p == nil || p.root != filepath.Join(dir, "p") || p.scenario != "build.yaml" {
+		test.Fatalf("unexpected project %+v", p)
+	}
+	if wd := p.workDir(filepath.Join(dir, "p/src/a"), "", DefaultCacheFile); wd != filepath.Join(dir, "p/bin") {
+		test.Errorf("work dir %s", wd)
+	}
+	if wd := p.workDir(filepath.Join(dir, "p/bin"), "dbg", DefaultCacheFile); wd != filepath.Join(dir, "p/bin") {
+		test.Errorf("existing work dir not used: %s", wd)
+	}
+
+	if p, err = discoverProject(filepath.Join(dir, "m/sub")); err != nil {
+		test.Fatal(err)
+	}
+	if p == nil || p.root != filepath.Join(dir, "m/sub") {
+		test.Fatalf("unexpected project %+v", p)
+	}
+
+	if p, err = discoverProject(filepath.Join(dir, "m")); err != nil {
+		test.Fatal(err)
+	}
+	if p == nil || p.buildDir != "out" {
+		test.Fatalf("unexpected project %+v", p)
+	}
+	if wd := p.workDir(dir, "dbg", DefaultCacheFile); wd != filepath.Join(dir, "m/dbg") {
+		test.Errorf("work dir %s", wd)
+	}
+}
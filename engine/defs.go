@@ -0,0 +1,277 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// definitions - макроопределения сценария (секция defs).
+type definitions map[string]definition
+
+// definition - макроопределение сценария. Состоит из частей, каждая из
+// которых может иметь условие применения. В сценарии задается списком
+// значений, объектом со значениями и условием или списком таких объектов.
+type definition []*defPart
+
+type defPart struct {
+	Values []string `json:"values"`
+	Condition
+
+	// Режим объединения с одноименными макроопределениями
+	mode defMode
+	// Место определения части
+	loc location
+}
+
+// defMode - режим объединения одноименных макроопределений, задается
+// суффиксом имени макроса.
+type defMode int
+
+const (
+	// NAME - значения добавляются к значениям одноименного макроса
+	// (в операции - перекрывают глобальный макрос)
+	defPlain defMode = iota
+	// NAME+ - значения добавляются к значениям одноименного макроса
+	defAppend
+	// NAME! - значения заменяют значения одноименного макроса из сценариев
+	// с меньшим приоритетом
+	defReplace
+	// NAME? - значения используются, только если макрос не определен
+	defDefault
+)
+
+// defModeSuffixes отображает суффикс имени макроса в режим объединения.
+var defModeSuffixes = map[byte]defMode{
+	'+': defAppend,
+	'!': defReplace,
+	'?': defDefault,
+}
+
+// parseModes разбирает суффиксы режимов объединения в именах
+// макроопределений и возвращает макроопределения с именами без суффиксов.
+func (defs definitions) parseModes(s *session) (definitions, error) {
+	res := make(definitions, len(defs))
+	for key, d := range defs {
+		name, mode := key, defPlain
+		if len(key) > 1 {
+			if m, ok := defModeSuffixes[key[len(key)-1]]; ok {
+				name, mode = key[:len(key)-1], m
+			}
+		}
+
+		if prev, exists := res[name]; exists {
+			return nil, configErrorf("macro %s defined more than once: %s and %s",
+				name, prev[0].loc, d[0].loc)
+		}
+		for _, part := range d {
+			part.mode = mode
+			s.logf("macro %s defined at %s", key, part.loc)
+		}
+		res[name] = d
+	}
+	return res, nil
+}
+
+// UnmarshalJSON разбирает макроопределение, заданное списком значений,
+// объектом со значениями и условием или списком таких объектов.
+func (d *definition) UnmarshalJSON(b []byte) error {
+	if b = bytes.TrimSpace(b); len(b) != 0 && b[0] == '{' {
+		part := new(defPart)
+		if err := decodeStrict(b, part); err != nil {
+			return err
+		}
+		*d = definition{part}
+		return nil
+	}
+
+	var values []string
+	if err := json.Unmarshal(b, &values); err == nil {
+		*d = definition{{Values: values}}
+		return nil
+	}
+
+	var parts []*defPart
+	if err := decodeStrict(b, &parts); err != nil {
+		return err
+	}
+	*d = parts
+	return nil
+}
+
+// MarshalJSON записывает макроопределение без условий списком значений.
+func (d definition) MarshalJSON() ([]byte, error) {
+	for _, part := range d {
+		if part.conditional() {
+			return json.Marshal([]*defPart(d))
+		}
+	}
+	return json.Marshal(d.values())
+}
+
+// values возвращает значения всех частей макроопределения.
+func (d definition) values() []string {
+	res := make([]string, 0, 16)
+	for _, part := range d {
+		res = append(res, part.Values...)
+	}
+	return res
+}
+
+// isEnabled проверяет условие части макроопределения.
+func (part *defPart) isEnabled(def Defines, s *session) (bool, error) {
+	ok, err := part.enabled(def, s)
+	if err != nil {
+		return false, fmt.Errorf("%s: condition: %w", part.loc, err)
+	}
+	return ok, nil
+}
+
+// enabled возвращает части макроопределения, условия которых выполняются.
+func (d definition) enabled(def Defines, s *session) (definition, error) {
+	res := make(definition, 0, len(d))
+	for _, part := range d {
+		ok, err := part.isEnabled(def, s)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			res = append(res, part)
+		}
+	}
+	return res, nil
+}
+
+// defResolver отбирает части макроопределений, условия которых
+// выполняются. Перед проверкой условий части определяются макросы, на
+// которые ссылается условие, а также макросы, на которые ссылаются их
+// значения, поэтому результат не зависит от порядка обхода.
+type defResolver struct {
+	defs definitions
+	// встроенные и определенные макросы (значения не развернуты)
+	def Defines
+	// отобранные части определенных макросов
+	parts definitions
+	s     *session
+	// false - макрос в процессе определения, true - определен
+	done map[string]bool
+	// цепочка определяемых в данный момент макросов
+	stack []string
+	// true - на макрос из цепочки ссылается условие предыдущего макроса
+	conds []bool
+}
+
+// resolve определяет макрос name, cond - ссылка на макрос получена из
+// условия. Ссылки на неизвестные макросы пропускаются, о них сообщается
+// при подстановке. Циклы только через значения также пропускаются, о них
+// сообщается при развертывании значений.
+func (r *defResolver) resolve(name string, cond bool) error {
+	d, exists := r.defs[name]
+	done, visited := r.done[name]
+	if !exists || done {
+		return nil
+	}
+	if visited {
+		i := stringIndex(r.stack, name)
+		if !cond && !containsTrue(r.conds[i+1:]) {
+			return nil
+		}
+		cycle := append(r.stack[i:], name)
+		return macroErrorf("cyclic reference in macro conditions: %s",
+			strings.Join(cycle, " -> "))
+	}
+
+	r.done[name] = false
+	r.stack = append(r.stack, name)
+	r.conds = append(r.conds, cond)
+
+	for _, part := range d {
+		if err := r.resolveRefs(part.Condition.refs(), true); err != nil {
+			return err
+		}
+	}
+	enabled, err := d.enabled(r.def, r.s)
+	if err != nil {
+		return err
+	}
+	enabled = enabled.resolve()
+	values := enabled.values()
+	if err = r.resolveRefs(macroRefs(values), false); err != nil {
+		return err
+	}
+	r.parts[name], r.def[name] = enabled, values
+
+	r.stack = r.stack[:len(r.stack)-1]
+	r.conds = r.conds[:len(r.conds)-1]
+	r.done[name] = true
+	return nil
+}
+
+// resolveRefs определяет макросы names, cond - ссылки получены из условия.
+func (r *defResolver) resolveRefs(names []string, cond bool) error {
+	for _, name := range names {
+		if err := r.resolve(name, cond); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// containsTrue возвращает true, если хотя бы одно из значений flags
+// истинно.
+func containsTrue(flags []bool) bool {
+	for _, f := range flags {
+		if f {
+			return true
+		}
+	}
+	return false
+}
+
+// macroRefs возвращает имена макросов, вызываемых в значениях values.
+func macroRefs(values []string) []string {
+	var names []string
+	for _, val := range values {
+		for _, call := range macroRegexp.FindAllString(val, -1) {
+			name, _ := parseMacroCall(call)
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// resolve применяет режимы объединения частей макроопределения. Части
+// должны быть упорядочены по убыванию приоритета сценариев, в которых они
+// определены (в порядке загрузки сценариев). Части сценариев применяются
+// начиная с сценария с наименьшим приоритетом: NAME и NAME+ добавляют
+// значения перед имеющимися, NAME! заменяет имеющиеся. Части NAME?
+// используются, только если других частей нет, при этом выбирается
+// часть сценария с наибольшим приоритетом.
+func (d definition) resolve() definition {
+	res := make(definition, 0, len(d))
+	var defaults definition
+
+	for end := len(d); end > 0; {
+		start := end - 1
+		for start > 0 && d[start-1].loc.file == d[end-1].loc.file {
+			start--
+		}
+		layer := d[start:end]
+		end = start
+
+		switch layer[0].mode {
+		case defDefault:
+			defaults = layer
+		case defReplace:
+			res = append(res[:0], layer...)
+		default:
+			res = append(append(definition{}, layer...), res...)
+		}
+	}
+
+	if len(res) == 0 {
+		return defaults
+	}
+	return res
+}
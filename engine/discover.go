@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// имя файла-метки корневой директории проекта, файл может содержать
+	// путь к директории сборки относительно корневой
+	rootMarker = ".bldroot"
+	// имя сценария по умолчанию (без расширения)
+	defaultScenario = "build"
+	// директория сборки по умолчанию относительно корневой
+	defaultBuildDir = "bin"
+	// файл кэша по умолчанию, путь относительно рабочей директории
+	DefaultCacheFile = "bldcache.json"
+)
+
+// project описывает найденный проект.
+type project struct {
+	// корневая директория, абсолютный путь
+	root string
+	// сценарий относительно корневой директории
+	scenario string
+	// директория сборки, указанная в файле-метке
+	buildDir string
+}
+
+// discoverProject ищет корневую директорию проекта, поднимаясь от
+// директории dir. Корневой считается первая директория, содержащая
+// файл-метку rootMarker или сценарий по умолчанию. Возвращает nil, если
+// проект не найден.
+func discoverProject(dir string) (*project, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		marker := filepath.Join(dir, rootMarker)
+		if body, err := ioutil.ReadFile(marker); err == nil {
+			return &project{
+				root:     dir,
+				scenario: findScenario(dir, defaultScenario),
+				buildDir: strings.TrimSpace(firstLine(string(body))),
+			}, nil
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		scenario := findScenario(dir, defaultScenario)
+		if fi, _ := os.Stat(filepath.Join(dir, scenario)); fi != nil {
+			return &project{root: dir, scenario: scenario}, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// workDir выбирает рабочую директорию для проекта, найденного из
+// директории wd: если wd уже является рабочей директорией (содержит файл
+// кэша cache), она и используется, иначе используется директория сборки
+// buildDir (относительно корневой директории проекта). Если buildDir не
+// указана, используется директория из файла-метки или директория по
+// умолчанию.
+func (p *project) workDir(wd, buildDir, cache string) string {
+	if !filepath.IsAbs(cache) {
+		cache = filepath.Join(wd, cache)
+	}
+	if fi, _ := os.Stat(cache); fi != nil {
+		return wd
+	}
+
+	if len(buildDir) == 0 {
+		buildDir = p.buildDir
+	}
+	if len(buildDir) == 0 {
+		buildDir = defaultBuildDir
+	}
+	if !filepath.IsAbs(buildDir) {
+		buildDir = filepath.Join(p.root, buildDir)
+	}
+	return buildDir
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UsageError - ошибка в опциях или аргументах командной строки.
+type UsageError struct {
+	Err error
+}
+
+func (e *UsageError) Error() string { return e.Err.Error() }
+func (e *UsageError) Unwrap() error { return e.Err }
+
+// usageErrorf создает UsageError с указанным сообщением.
+func usageErrorf(f string, a ...interface{}) error {
+	return &UsageError{fmt.Errorf(f, a...)}
+}
+
+// ConfigError - ошибка сценария: файл не найден или не читается, ошибка
+// синтаксиса, неизвестные поля, проблемы, найденные при проверке.
+type ConfigError struct {
+	Err error
+	// Проблемы, найденные при проверке сценария (по одной на строку, с
+	// указанием файла и позиции), если ошибка вызвана ими. Не входят в
+	// текст ошибки, их выводит вызывающий.
+	Problems []string
+}
+
+func (e *ConfigError) Error() string { return e.Err.Error() }
+func (e *ConfigError) Unwrap() error { return e.Err }
+
+// configErrorf создает ConfigError с указанным сообщением.
+func configErrorf(f string, a ...interface{}) error {
+	return &ConfigError{Err: fmt.Errorf(f, a...)}
+}
+
+// MacroError - ошибка макроподстановки: неизвестный макрос, циклическая
+// ссылка, слишком глубокая вложенность, отсутствующая переменная среды.
+type MacroError struct {
+	Err error
+}
+
+func (e *MacroError) Error() string { return e.Err.Error() }
+func (e *MacroError) Unwrap() error { return e.Err }
+
+// macroErrorf создает MacroError с указанным сообщением.
+func macroErrorf(f string, a ...interface{}) error {
+	return &MacroError{fmt.Errorf(f, a...)}
+}
+
+// ToolError - ошибка вызова утилиты операции.
+type ToolError struct {
+	Op   string
+	Argv []string
+	// код завершения утилиты, -1 если утилита не была запущена
+	ExitCode int
+	Err      error
+}
+
+func (e *ToolError) Error() string {
+	return fmt.Sprintf("operation %s: command %s: %s",
+		e.Op, strings.Join(e.Argv, " "), e.Err)
+}
+
+func (e *ToolError) Unwrap() error { return e.Err }
+
+// CacheError - ошибка чтения кэша: файл не читается или поврежден.
+type CacheError struct {
+	Path string
+	Err  error
+}
+
+func (e *CacheError) Error() string {
+	return fmt.Sprintf("unable load cache %s: %s", e.Path, e.Err)
+}
+
+func (e *CacheError) Unwrap() error { return e.Err }
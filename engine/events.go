@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Виды событий сборки (поле Event).
+const (
+	EventOpStart  = "op-start"
+	EventOpFinish = "op-finish"
+	EventCache    = "cache"
+	EventExec     = "exec"
+)
+
+// Event - событие сборки, записывается в журнал событий одной строкой JSON.
+type Event struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+	Op    string    `json:"op"`
+
+	// cache: файл, решение (process или skip) и цепочка причин обработки
+	File     string   `json:"file,omitempty"`
+	Decision string   `json:"decision,omitempty"`
+	Reason   []string `json:"reason,omitempty"`
+
+	// exec: командная строка и код завершения утилиты, номер исполнителя
+	// (от 1 до Options.Jobs), на котором выполнялся вызов
+	Argv     []string `json:"argv,omitempty"`
+	ExitCode *int     `json:"exit_code,omitempty"`
+	Worker   int      `json:"worker,omitempty"`
+
+	// exec, op-finish: время начала и длительность в миллисекундах
+	Start    time.Time `json:"-"`
+	Duration *float64  `json:"duration_ms,omitempty"`
+	// op-finish: количество обработанных файлов
+	Files *int `json:"files,omitempty"`
+	// exec, op-finish: ошибка выполнения
+	Error string `json:"error,omitempty"`
+}
+
+// EventSink - получатель событий сборки. Получатели вызываются
+// последовательно, в том числе при параллельном выполнении утилит.
+type EventSink func(e *Event)
+
+// JSONEvents возвращает получателя, записывающего события в w в формате
+// JSON, по одному событию на строку. Ошибки записи игнорируются.
+func JSONEvents(w io.Writer) EventSink {
+	enc := json.NewEncoder(w)
+	return func(e *Event) {
+		enc.Encode(e)
+	}
+}
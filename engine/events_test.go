@@ -0,0 +1,42 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestJSONEvents(test *testing.T) {
+	var buf bytes.Buffer
+	s := newSession(&Options{Events: []EventSink{JSONEvents(&buf)}})
+
+	code := 2
+	s.emit(&Event{Event: EventExec, Op: "cc", Argv: []string{"cc", "a.c"},
+		ExitCode: &code, Start: time.Now().Add(-time.Second)})
+	s.emit(&Event{Event: EventCache, Op: "cc", File: "a.c", Decision: "skip"})
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		test.Fatalf("expected 2 lines, actual:\n%s", buf.String())
+	}
+
+	var e map[string]interface{}
+	if err := json.Unmarshal(lines[0], &e); err != nil {
+		test.Fatal(err)
+	}
+	if e["event"] != "exec" || e["exit_code"] != 2.0 {
+		test.Errorf("unexpected event %s", lines[0])
+	}
+	if d, _ := e["duration_ms"].(float64); d < 1000 {
+		test.Errorf("unexpected duration %v", e["duration_ms"])
+	}
+
+	e = nil
+	if err := json.Unmarshal(lines[1], &e); err != nil {
+		test.Fatal(err)
+	}
+	if _, exists := e["duration_ms"]; exists || e["decision"] != "skip" {
+		test.Errorf("unexpected event %s", lines[1])
+	}
+}
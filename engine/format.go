@@ -0,0 +1,201 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// configFormat описывает формат файла сценария.
+type configFormat struct {
+	// преобразует содержимое сценария в json
+	toJSON func(data []byte) ([]byte, error)
+	// true, если места в полученном json совпадают с местами в файле
+	positions bool
+}
+
+// карта, отображающая расширение файла сценария в его формат. Файлы с
+// неизвестным расширением считаются json-файлами.
+var configFormats = map[string]configFormat{
+	".json": {relaxedJSON, true},
+	".yaml": {yamlToJSON, false},
+	".yml":  {yamlToJSON, false},
+	".toml": {tomlToJSON, false},
+}
+
+// расширения, с которыми ищется сценарий, указанный без расширения,
+// в порядке приоритета.
+var configExts = []string{".json", ".yaml", ".yml", ".toml"}
+
+// getConfigFormat возвращает формат сценария по расширению файла.
+func getConfigFormat(path string) configFormat {
+	if format, ok := configFormats[filepath.Ext(path)]; ok {
+		return format
+	}
+	return configFormats[".json"]
+}
+
+// findScenario возвращает путь к сценарию name относительно директории
+// dir. Если расширение не указано, ищется первый существующий файл с одним
+// из расширений configExts, если такого нет - используется расширение .json.
+func findScenario(dir, name string) string {
+	if len(filepath.Ext(name)) != 0 {
+		return name
+	}
+
+	for _, ext := range configExts {
+		if fi, _ := os.Stat(filepath.Join(dir, name+ext)); fi != nil {
+			return name + ext
+		}
+	}
+	return name + ".json"
+}
+
+// relaxedJSON преобразует json с комментариями (// и /* */) и
+// завершающими запятыми в списках и объектах в строгий json. Комментарии
+// и запятые заменяются пробелами, поэтому места в полученных данных
+// совпадают с местами в исходных. Удаляется только запятая после элемента,
+// пустые элементы ([,] или [1,,]) остаются ошибкой.
+func relaxedJSON(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	copy(out, data)
+
+	// позиция последней запятой после элемента, после которой были только
+	// пробелы
+	comma := -1
+	// true, если последним был элемент (значение или закрывающая скобка)
+	value := false
+
+	for i := 0; i < len(out); i++ {
+		switch c := out[i]; {
+		case c == '"':
+			comma, value = -1, true
+			for i++; i < len(out) && out[i] != '"'; i++ {
+				if out[i] == '\\' {
+					i++
+				}
+			}
+
+		case c == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+
+		case c == '/' && i+1 < len(out) && out[i+1] == '*':
+			end := bytes.Index(out[i+2:], []byte("*/"))
+			if end < 0 {
+				loc := offsetLocation("", out, int64(i))
+				return nil, fmt.Errorf("unterminated comment at line %d", loc.line)
+			}
+			for end += i + 4; i < end; i++ {
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+			i--
+
+		case c == ',':
+			comma = -1
+			if value {
+				comma = i
+			}
+			value = false
+
+		case c == ']' || c == '}':
+			if comma >= 0 {
+				out[comma] = ' '
+			}
+			comma, value = -1, true
+
+		case c == '[' || c == '{' || c == ':':
+			comma, value = -1, false
+
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+
+		default:
+			comma, value = -1, true
+		}
+	}
+
+	return out, nil
+}
+
+func yamlToJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonCompatible(v, ""))
+}
+
+func tomlToJSON(data []byte) ([]byte, error) {
+	var v map[string]interface{}
+	if err := toml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonCompatible(v, ""))
+}
+
+// boolFields - имена логических полей операции, значения которых не
+// преобразуются в строки.
+var boolFields = func() map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(Operation{})
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.Type.Kind() == reflect.Bool {
+			fields[strings.Split(f.Tag.Get("json"), ",")[0]] = true
+		}
+	}
+	return fields
+}()
+
+// jsonCompatible приводит разобранные yaml- и toml-данные к виду, который
+// декодируется так же, как json-сценарий: словари с ключами произвольного
+// типа заменяются словарями со строковыми ключами, а числа и логические
+// значения (кроме значений логических полей операций) - строками, так как
+// все значения в сценарии строковые. key - ключ, под которым находится v.
+func jsonCompatible(v interface{}, key string) interface{} {
+	switch x := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, val := range x {
+			name := fmt.Sprint(k)
+			m[name] = jsonCompatible(val, name)
+		}
+		return m
+
+	case map[string]interface{}:
+		for k, val := range x {
+			x[k] = jsonCompatible(val, k)
+		}
+		return x
+
+	case []map[string]interface{}:
+		for i := range x {
+			x[i] = jsonCompatible(x[i], "").(map[string]interface{})
+		}
+		return x
+
+	case []interface{}:
+		for i := range x {
+			x[i] = jsonCompatible(x[i], "")
+		}
+		return x
+
+	case nil, string:
+		return v
+
+	case bool:
+		if boolFields[key] {
+			return v
+		}
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,227 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Виды узлов графа операций.
+const (
+	nodeOperation = "operation"
+	nodeSource    = "source"
+	nodeHeader    = "header"
+	nodeOutput    = "output"
+)
+
+// Виды ребер графа операций, ребро направлено от зависимого узла к узлу,
+// от которого он зависит.
+const (
+	// операция зависит от операции (deps)
+	edgeDep = "dep"
+	// операция обрабатывает исходный файл
+	edgeSource = "source"
+	// файл включает файл-зависимость
+	edgeInclude = "include"
+	// файл создан операцией
+	edgeOutput = "output"
+)
+
+// GraphNode - узел графа: операция или файл.
+type GraphNode struct {
+	ID    string `json:"id"`
+	Kind  string `json:"kind"`
+	Descr string `json:"descr,omitempty"`
+}
+
+// GraphEdge - ребро графа.
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"`
+}
+
+// Graph - граф операций сценария, при необходимости дополненный файлами
+// из кэша.
+type Graph struct {
+	Nodes []*GraphNode `json:"nodes"`
+	Edges []*GraphEdge `json:"edges"`
+
+	known map[string]bool
+}
+
+// opID возвращает идентификатор операции в графе: имя или порядковый номер
+// (#1, #2, ...) для операции без имени.
+func opID(op *Operation, i int) string {
+	if len(op.Name) != 0 {
+		return op.Name
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
+
+// buildGraph строит граф операций конфигурации. Если cache не nil, в граф
+// добавляются исходные файлы, обработанные операциями, их зависимости и
+// созданные операциями файлы.
+func buildGraph(conf *Config, cache Cache) *Graph {
+	g := &Graph{known: make(map[string]bool)}
+
+	// идентификаторы операций по индексу и по имени; операции без имени
+	// кэш не различает, поэтому они доступны по имени, только если такая
+	// операция одна
+	ids := make([]string, len(conf.Ops))
+	byName := make(map[string]string, len(conf.Ops))
+	unnamed := 0
+	for i, op := range conf.Ops {
+		ids[i] = opID(op, i)
+		g.node(ids[i], nodeOperation, op.Descr)
+		if len(op.Name) == 0 {
+			unnamed++
+		}
+		byName[op.Name] = ids[i]
+	}
+	if unnamed > 1 {
+		delete(byName, "")
+	}
+	for i, op := range conf.Ops {
+		for _, dep := range op.Deps {
+			if id, exists := byName[dep]; exists {
+				g.edge(ids[i], id, edgeDep)
+			}
+		}
+	}
+
+	if cache == nil {
+		return g
+	}
+
+	paths := make([]string, 0, len(cache))
+	for path := range cache {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		item := cache[path]
+		if item.Output != nil {
+			if id, exists := byName[*item.Output]; exists {
+				g.node(path, nodeOutput, "")
+				g.edge(path, id, edgeOutput)
+			}
+		}
+		for i, op := range conf.Ops {
+			id, exists := byName[op.Name]
+			if exists && id == ids[i] && item.Commands[op.Name] != nil {
+				g.node(path, nodeSource, "")
+				g.edge(id, path, edgeSource)
+			}
+		}
+	}
+
+	// зависимости добавляются только для файлов, уже попавших в граф
+	for i := 0; i < len(g.Nodes); i++ {
+		n := g.Nodes[i]
+		item, exists := cache[n.ID]
+		if n.Kind == nodeOperation || !exists {
+			continue
+		}
+		for _, dep := range directCachedDeps(cache, item) {
+			g.node(dep, nodeHeader, "")
+			g.edge(n.ID, dep, edgeInclude)
+		}
+	}
+
+	return g
+}
+
+// directCachedDeps возвращает зависимости файла, не являющиеся
+// зависимостями других его зависимостей (кэш хранит транзитивное
+// замыкание).
+func directCachedDeps(cache Cache, item *FileStateSnap) []string {
+	indirect := make(map[string]bool)
+	for _, dep := range item.Depends {
+		if d, exists := cache[dep]; exists {
+			for _, dd := range d.Depends {
+				if dd != dep {
+					indirect[dd] = true
+				}
+			}
+		}
+	}
+
+	deps := make([]string, 0, len(item.Depends))
+	for _, dep := range item.Depends {
+		if !indirect[dep] {
+			deps = append(deps, dep)
+		}
+	}
+	return deps
+}
+
+func (g *Graph) node(id, kind, descr string) {
+	if g.known[id] {
+		return
+	}
+	g.known[id] = true
+	g.Nodes = append(g.Nodes, &GraphNode{ID: id, Kind: kind, Descr: descr})
+}
+
+func (g *Graph) edge(from, to, kind string) {
+	g.Edges = append(g.Edges, &GraphEdge{From: from, To: to, Kind: kind})
+}
+
+// WriteText выводит операции и их зависимости в виде "name: deps...".
+func (g *Graph) WriteText(w io.Writer) {
+	for _, n := range g.Nodes {
+		if n.Kind != nodeOperation {
+			continue
+		}
+		fmt.Fprintf(w, "%s:", n.ID)
+		for _, e := range g.Edges {
+			if e.From == n.ID && e.Kind == edgeDep {
+				fmt.Fprintf(w, " %s", e.To)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// WriteDOT выводит граф в формате Graphviz DOT.
+func (g *Graph) WriteDOT(w io.Writer) {
+	shapes := map[string]string{
+		nodeOperation: "box",
+		nodeSource:    "ellipse",
+		nodeHeader:    "note",
+		nodeOutput:    "component",
+	}
+
+	fmt.Fprintln(w, "digraph bld {")
+	for _, n := range g.Nodes {
+		label := n.ID
+		if len(n.Descr) != 0 {
+			label += "\n" + n.Descr
+		}
+		fmt.Fprintf(w, "\t%s [shape=%s, label=%s];\n",
+			strconv.Quote(n.ID), shapes[n.Kind], strconv.Quote(label))
+	}
+	for _, e := range g.Edges {
+		style := ""
+		if e.Kind != edgeDep {
+			style = " [style=dashed]"
+		}
+		fmt.Fprintf(w, "\t%s -> %s%s;\n",
+			strconv.Quote(e.From), strconv.Quote(e.To), style)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// WriteJSON выводит граф в формате JSON.
+func (g *Graph) WriteJSON(w io.Writer) error {
+	b, err := json.MarshalIndent(g, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBuildGraph(test *testing.T) {
+	conf := &Config{Ops: []*Operation{
+		{Name: "cc", Descr: "compile"},
+		{Name: "ld", Deps: []string{"cc"}},
+	}}
+	cc := "cc"
+	cache := Cache{
+		"main.c": {Path: "main.c", Depends: []string{"a.h", "b.h"},
+			Commands: map[string][]string{"cc": {"cc"}}},
+		"a.h":    {Path: "a.h", Depends: []string{"b.h"}},
+		"b.h":    {Path: "b.h"},
+		"main.o": {Path: "main.o", Output: &cc},
+	}
+
+	var buf bytes.Buffer
+	buildGraph(conf, nil).WriteText(&buf)
+	if buf.String() != "cc:\nld: cc\n" {
+		test.Errorf("unexpected text graph:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	buildGraph(conf, cache).WriteDOT(&buf)
+	expected := `digraph bld {
+	"cc" [shape=box, label="cc\ncompile"];
+	"ld" [shape=box, label="ld"];
+	"main.c" [shape=ellipse, label="main.c"];
+	"main.o" [shape=component, label="main.o"];
+	"a.h" [shape=note, label="a.h"];
+	"b.h" [shape=note, label="b.h"];
+	"ld" -> "cc";
+	"cc" -> "main.c" [style=dashed];
+	"main.o" -> "cc" [style=dashed];
+	"main.c" -> "a.h" [style=dashed];
+	"a.h" -> "b.h" [style=dashed];
+}
+`
+	if buf.String() != expected {
+		test.Errorf("unexpected DOT graph:\n%s", buf.String())
+	}
+}
+
+func TestBuildGraphUnnamed(test *testing.T) {
+	conf := &Config{Ops: []*Operation{
+		{Deps: []string{"gen"}},
+		{Name: "gen"},
+		{Deps: []string{"cc"}},
+		{Name: "cc", Deps: []string{"gen"}},
+	}}
+	cache := Cache{
+		"a.c": {Path: "a.c", Commands: map[string][]string{"": {"x"}, "cc": {"cc"}}},
+	}
+
+	var buf bytes.Buffer
+	buildGraph(conf, cache).WriteText(&buf)
+	expected := "#1: gen\ngen:\n#3: cc\ncc: gen\n"
+	if buf.String() != expected {
+		test.Errorf("unexpected text graph:\n%s", buf.String())
+	}
+
+	g := buildGraph(conf, cache)
+	for _, e := range g.Edges {
+		if e.Kind == edgeSource && e.From != "cc" {
+			test.Errorf("unexpected source edge %s -> %s", e.From, e.To)
+		}
+	}
+
+	conf.Ops = conf.Ops[:2]
+	g = buildGraph(conf, cache)
+	if len(g.Edges) != 2 || g.Edges[1].From != "#1" || g.Edges[1].To != "a.c" {
+		test.Errorf("unexpected edges %+v", g.Edges)
+	}
+}
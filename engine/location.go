@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// location - место определения элемента сценария: файл и, если известны,
+// строка и столбец.
+type location struct {
+	file string
+	line int
+	col  int
+}
+
+func (loc location) String() string {
+	if loc.line == 0 {
+		return loc.file
+	}
+	return fmt.Sprintf("%s:%d:%d", loc.file, loc.line, loc.col)
+}
+
+// offsetLocation вычисляет строку и столбец по смещению в data.
+func offsetLocation(file string, data []byte, offset int64) location {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+
+	loc := location{file: file, line: 1, col: 1}
+	for _, c := range data[:offset] {
+		if c == '\n' {
+			loc.line++
+			loc.col = 1
+		} else {
+			loc.col++
+		}
+	}
+	return loc
+}
+
+// errorLocation возвращает место ошибки разбора json-сценария или
+// только имя файла, если место неизвестно.
+func errorLocation(file string, data []byte, err error) location {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		return offsetLocation(file, data, e.Offset)
+	case *json.UnmarshalTypeError:
+		return offsetLocation(file, data, e.Offset)
+	}
+	return location{file: file}
+}
+
+// locateConfig устанавливает места определения операций и макроопределений
+// сценария conf, разобранного из json-данных data. Должна вызываться до
+// разбора суффиксов имен макроопределений.
+func locateConfig(conf *Config, data []byte) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if !expectDelim(dec, '{') {
+		return
+	}
+
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return
+		}
+
+		switch key {
+		case "defs":
+			if !expectDelim(dec, '{') {
+				return
+			}
+			for dec.More() {
+				loc := nextLocation(conf.path, data, dec)
+				name, err := dec.Token()
+				if err != nil {
+					return
+				}
+				if d, ok := conf.Defs[fmt.Sprint(name)]; ok {
+					for _, part := range d {
+						part.loc = loc
+					}
+				}
+				if !skipValue(dec) {
+					return
+				}
+			}
+			dec.Token()
+
+		case "ops":
+			if !expectDelim(dec, '[') {
+				return
+			}
+			for i := 0; dec.More(); i++ {
+				if i < len(conf.Ops) {
+					conf.Ops[i].loc = nextLocation(conf.path, data, dec)
+				}
+				if !skipValue(dec) {
+					return
+				}
+			}
+			dec.Token()
+
+		default:
+			if !skipValue(dec) {
+				return
+			}
+		}
+	}
+}
+
+// nextLocation возвращает место начала следующей лексемы.
+func nextLocation(file string, data []byte, dec *json.Decoder) location {
+	offset := dec.InputOffset()
+	for offset < int64(len(data)) {
+		switch data[offset] {
+		case ' ', '\t', '\r', '\n', ',', ':':
+			offset++
+			continue
+		}
+		break
+	}
+	return offsetLocation(file, data, offset)
+}
+
+// expectDelim читает следующую лексему и проверяет, что она является
+// указанным разделителем.
+func expectDelim(dec *json.Decoder, delim json.Delim) bool {
+	tok, err := dec.Token()
+	return err == nil && tok == delim
+}
+
+// skipValue пропускает следующее значение.
+func skipValue(dec *json.Decoder) bool {
+	var raw json.RawMessage
+	return dec.Decode(&raw) == nil
+}
@@ -0,0 +1,315 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type Defines map[string][]string
+
+var (
+	envMacroRegexp = regexp.MustCompile(`\$\{.*?\}`)
+	macroRegexp    = regexp.MustCompile(`\$\(\/?[^@$()]*\)`)
+	embMacroRegexp = regexp.MustCompile(`\$\(\/?@\)`)
+)
+
+// Раскручивает определения, делая всевозможные подстановки.
+// Макроопределения раскрываются в порядке зависимостей (сначала те, на
+// которые ссылаются другие), поэтому результат не зависит от порядка
+// обхода. При обнаружении циклической ссылки возвращается ошибка с
+// описанием цикла.
+func (def Defines) bootstrap(s *session) error {
+	names := make([]string, 0, len(def))
+	for name := range def {
+		names = append(names, name)
+	}
+	return def.bootstrapNames(names, s)
+}
+
+// bootstrapNames разворачивает только указанные макроопределения, считая
+// остальные уже развернутыми.
+func (def Defines) bootstrapNames(names []string, s *session) error {
+	b := &bootstrapper{def: def, s: s, expanded: make(map[string]bool)}
+	for name := range def {
+		b.expanded[name] = true
+	}
+	for _, name := range names {
+		delete(b.expanded, name)
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		if err := b.expand(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bootstrapper хранит состояние обхода графа ссылок между
+// макроопределениями.
+type bootstrapper struct {
+	def Defines
+	s   *session
+	// false - макрос в процессе раскрытия, true - раскрыт
+	expanded map[string]bool
+	// цепочка раскрываемых в данный момент макросов
+	stack []string
+}
+
+// expand раскрывает макроопределение name, предварительно раскрыв все
+// макроопределения, на которые оно ссылается.
+func (b *bootstrapper) expand(name string) error {
+	done, visited := b.expanded[name]
+	if done {
+		return nil
+	}
+	if visited {
+		cycle := append(b.stack[stringIndex(b.stack, name):], name)
+		return macroErrorf("cyclic reference in macro definitions: %s",
+			strings.Join(cycle, " -> "))
+	}
+
+	values, exists := b.def[name]
+	if !exists {
+		return macroErrorf("Macro definition with name %s not found", name)
+	}
+
+	b.expanded[name] = false
+	b.stack = append(b.stack, name)
+
+	res := make([]string, 0, len(values))
+	for _, val := range values {
+		expanded, err := b.expandValue(val)
+		if err != nil {
+			return err
+		}
+		res = append(res, expanded...)
+	}
+	b.def[name] = res
+
+	b.stack = b.stack[:len(b.stack)-1]
+	b.expanded[name] = true
+	return nil
+}
+
+// expandValue выполняет подстановку макровызовов в значение val. Перед
+// каждой подстановкой вызываемый макрос раскрывается полностью.
+func (b *bootstrapper) expandValue(val string) ([]string, error) {
+	input := []string{val}
+
+	for level := 0; ; level++ {
+		if level > b.s.level() {
+			return nil, macroErrorf("too deep nesting of macro-calls in %s", val)
+		}
+
+		result := make([]string, 0, len(input))
+		found := false
+		for _, str := range input {
+			macroCall := macroRegexp.FindString(str)
+			if len(macroCall) == 0 {
+				result = append(result, str)
+				continue
+			}
+			found = true
+
+			name, basePath := parseMacroCall(macroCall)
+			if err := b.expand(name); err != nil {
+				return nil, err
+			}
+
+			values := b.def[name]
+			if basePath {
+				values = basePathModif(values)
+			}
+			for _, v := range values {
+				result = append(result, strings.Replace(str, macroCall, v, 1))
+			}
+		}
+
+		input = result
+		if !found {
+			break
+		}
+	}
+
+	for i := range input {
+		var err error
+		if input[i], err = expandEnvVars(input[i], b.s); err != nil {
+			return nil, err
+		}
+	}
+	b.s.logf("macro: [%s] -> %v", val, input)
+
+	return input, nil
+}
+
+// parseMacroCall извлекает из макровызова имя макроса и признак
+// модификатора '/'.
+func parseMacroCall(macroCall string) (name string, basePath bool) {
+	name = macroCall[2 : len(macroCall)-1]
+	if len(name) > 0 && name[0] == '/' {
+		return name[1:], true
+	}
+	return name, false
+}
+
+func (def Defines) substituteUserDefs(input []string, s *session) ([]string, error) {
+	return def.substituteDefs(input, macroRegexp, s)
+}
+
+func (def Defines) substituteDefs(input []string, r *regexp.Regexp, s *session) ([]string, error) {
+	return def.substituteQuoted(input, r, nil, s)
+}
+
+// substituteQuoted делает подстановки в значения input, пропуская каждое
+// подставляемое значение макроопределения или переменной среды через
+// quote (если не nil).
+func (def Defines) substituteQuoted(input []string, r *regexp.Regexp, quote func(string) string, s *session) ([]string, error) {
+	res := make([]string, 0, 64)
+	for _, val := range input {
+		values, err := def.substitute(val, r, quote, s)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, values...)
+	}
+	return res, nil
+}
+
+// Делает подстановки определений на места макровызовов в значение val.
+// Если quote не nil, подставляемые значения пропускаются через quote.
+func (def Defines) substitute(val string, re *regexp.Regexp, quote func(string) string, s *session) ([]string, error) {
+	input := []string{val}
+	level := 0
+
+	for {
+		// выходные значения на каждой итерации
+		result := make([]string, 0, 16)
+
+		f := false
+		for _, str := range input {
+			// поиск макровызова
+			macroCall := re.FindString(str)
+
+			if len(macroCall) != 0 {
+				f = true
+			} else {
+				continue
+			}
+
+			// извлечение имени и модификатора
+			name, basePath := parseMacroCall(macroCall)
+
+			// поиск значения
+			values, exists := def[name]
+			if !exists {
+				return nil, macroErrorf("Macro definition with name %s not found", name)
+			}
+
+			// применение модификатора
+			if basePath {
+				values = basePathModif(values)
+			}
+
+			// подстановка каждым значением макроопределения
+			for _, val := range values {
+				if quote != nil {
+					val = quote(val)
+				}
+				subs := strings.Replace(str, macroCall, val, 1)
+				result = append(result, subs)
+			}
+		}
+
+		if !f {
+			// expand enveronment
+			for i := range input {
+				var err error
+				if input[i], err = replaceEnvVars(input[i], quote, s); err != nil {
+					return nil, err
+				}
+			}
+
+			if len(input) == 0 || val != input[0] {
+				s.logf("macro L%d: [%s] -> %v", level, val, input)
+			}
+
+			return input, nil
+		}
+
+		input = result
+		level++
+
+		if level > s.level() {
+			return nil, macroErrorf("cyclic reference in macro-call %s or depends", val)
+		}
+	}
+}
+
+// Модификатор значений переменной - базовый путь.
+func basePathModif(v []string) []string {
+	short := make([]string, len(v))
+	for i, _ := range v {
+		short[i] = filepath.Base(v[i])
+	}
+	return short
+}
+
+// getEnvVar возвращает значение переменной среды для вызова вида ${VAR},
+// ${VAR:-default} или ${VAR:?message}. Для ${VAR:-default} при отсутствии
+// (или пустом значении) переменной подставляется default, для
+// ${VAR:?message} возвращается ошибка с сообщением message. В строгом режиме
+// отсутствие переменной без значения по умолчанию - ошибка.
+func getEnvVar(macro string, s *session) (string, error) {
+	v := macro[2 : len(macro)-1]
+
+	name, op, arg := v, "", ""
+	if i := strings.Index(v, ":"); i >= 0 && i+1 < len(v) &&
+		(v[i+1] == '-' || v[i+1] == '?') {
+		name, op, arg = v[:i], v[i:i+2], v[i+2:]
+	}
+
+	value, exists := os.LookupEnv(name)
+	switch {
+	case op == ":-" && len(value) == 0:
+		value = arg
+	case op == ":?" && len(value) == 0:
+		if len(arg) == 0 {
+			arg = "not set"
+		}
+		return "", macroErrorf("environment variable %s: %s", name, arg)
+	case !exists && s.strict():
+		return "", macroErrorf("environment variable %s is not set", name)
+	case !exists:
+		s.logf("os env: %s is not set, expanded to empty string", name)
+	}
+
+	s.logf("os env: %s => %s", v, value)
+	return value, nil
+}
+
+// expandEnvVars подставляет значения переменных среды в строку str.
+func expandEnvVars(str string, s *session) (string, error) {
+	return replaceEnvVars(str, nil, s)
+}
+
+// replaceEnvVars подставляет значения переменных среды в строку str,
+// пропуская их через quote (если не nil).
+func replaceEnvVars(str string, quote func(string) string, s *session) (string, error) {
+	var first error
+	ex := envMacroRegexp.ReplaceAllStringFunc(str, func(macro string) string {
+		value, err := getEnvVar(macro, s)
+		if err != nil && first == nil {
+			first = err
+		}
+		if quote != nil {
+			return quote(value)
+		}
+		return value
+	})
+	return ex, first
+}
@@ -0,0 +1,193 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExpandEnvVars(test *testing.T) {
+	os.Setenv("BLD_TEST_SET", "value")
+	os.Setenv("BLD_TEST_EMPTY", "")
+	os.Unsetenv("BLD_TEST_UNSET")
+
+	cases := map[string]string{
+		"${BLD_TEST_SET}":             "value",
+		"${BLD_TEST_UNSET}":           "",
+		"${BLD_TEST_SET:-def}":        "value",
+		"${BLD_TEST_EMPTY:-def}":      "def",
+		"${BLD_TEST_UNSET:-def}/x":    "def/x",
+		"${BLD_TEST_SET:?required}":   "value",
+		"-I${BLD_TEST_UNSET:-inc}/$$": "-Iinc/$$",
+	}
+
+	for in, out := range cases {
+		if res, err := expandEnvVars(in, nil); err != nil || res != out {
+			test.Errorf("expandEnvVars(%q) = %q, expected %q", in, res, out)
+		}
+	}
+}
+
+func TestExpandEnvVarsErrors(test *testing.T) {
+	os.Unsetenv("BLD_TEST_UNSET")
+
+	expectError := func(in string, s *session) {
+		_, err := expandEnvVars(in, s)
+		var merr *MacroError
+		if !errors.As(err, &merr) {
+			test.Errorf("expandEnvVars(%q): expected macro error, actual %v", in, err)
+		}
+	}
+
+	expectError("${BLD_TEST_UNSET:?required}", nil)
+	expectError("${BLD_TEST_UNSET}", newSession(&Options{StrictEnv: true}))
+}
+
+func TestBootstrapOrder(test *testing.T) {
+	// результат не должен зависеть от порядка обхода карты
+	for i := 0; i < 20; i++ {
+		def := Defines{
+			"FILE":  []string{"$(/PATH)"},
+			"PATH":  []string{"$(DIR)/main.c"},
+			"DIR":   []string{"src", "lib"},
+			"FLAGS": []string{"-I$(DIR)", "$(FILE)"},
+		}
+		if err := def.bootstrap(nil); err != nil {
+			test.Fatal(err)
+		}
+
+		expected := []string{"-Isrc", "-Ilib", "main.c", "main.c"}
+		if strings.Join(def["FLAGS"], " ") != strings.Join(expected, " ") {
+			test.Fatalf("FLAGS = %v, expected %v", def["FLAGS"], expected)
+		}
+	}
+}
+
+func TestBootstrapNested(test *testing.T) {
+	def := Defines{
+		"KIND":      []string{"SRC", "INCL"},
+		"SRC-DIRS":  []string{"src"},
+		"INCL-DIRS": []string{"include"},
+		"DIRS":      []string{"$($(KIND)-DIRS)"},
+	}
+	if err := def.bootstrap(nil); err != nil {
+		test.Fatal(err)
+	}
+
+	if strings.Join(def["DIRS"], " ") != "src include" {
+		test.Errorf("DIRS = %v", def["DIRS"])
+	}
+}
+
+func TestBootstrapCycle(test *testing.T) {
+	def := Defines{
+		"A": []string{"$(B)"},
+		"B": []string{"x", "$(A)"},
+		"C": []string{"$(A)"},
+	}
+
+	err := def.bootstrap(nil)
+	if err == nil {
+		test.Fatal("cycle not detected")
+	}
+	var merr *MacroError
+	if !errors.As(err, &merr) {
+		test.Errorf("unexpected error type %T", err)
+	}
+	if msg := err.Error(); !strings.HasSuffix(msg, "A -> B -> A") {
+		test.Errorf("unexpected message: %s", msg)
+	}
+}
+
+func TestOperationScope(test *testing.T) {
+	defs := Defines{
+		"CFLAGS": []string{"-O2"},
+		"CC":     []string{"gcc"},
+		"OUT":    []string{"$(CFLAGS)"},
+	}
+	if err := defs.bootstrap(nil); err != nil {
+		test.Fatal(err)
+	}
+
+	op := new(Operation)
+	if err := json.Unmarshal([]byte(`{
+		"name": "op",
+		"defs": {
+			"CFLAGS": ["$(CFLAGS)", "-g"],
+			"CC": ["clang"]
+		},
+		"tool": "$(CC)",
+		"args": ["$(CFLAGS)", "$(OUT)"]
+	}`), op); err != nil {
+		test.Fatal(err)
+	}
+
+	scope, err := op.Scope(defs)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if err = op.CacheOpts(scope); err != nil {
+		test.Fatal(err)
+	}
+
+	if op.Tool != "clang" {
+		test.Errorf("tool = %s", op.Tool)
+	}
+	if v := strings.Join(op.cachedOpts, " "); v != "-O2 -g -O2" {
+		test.Errorf("args = %s", v)
+	}
+	if v := strings.Join(defs["CFLAGS"], " "); v != "-O2" {
+		test.Errorf("global CFLAGS changed: %s", v)
+	}
+}
+
+func TestDefinitionModes(test *testing.T) {
+	parse := func(path, body string) *Config {
+		conf := new(Config)
+		if err := json.Unmarshal([]byte(body), conf); err != nil {
+			test.Fatal(err)
+		}
+		conf.path = path
+		conf.setLocations(location{file: path})
+		defs, err := conf.Defs.parseModes(nil)
+		if err != nil {
+			test.Fatal(err)
+		}
+		conf.Defs = defs
+		return conf
+	}
+
+	root := parse("root.json", `{"defs": {
+		"CC!": ["clang"],
+		"CFLAGS+": ["-g"],
+		"PREFIX?": ["/opt"],
+		"MODE?": ["root"]
+	}}`)
+	root.combine(parse("a.json", `{"defs": {
+		"CC": ["gcc"],
+		"CFLAGS": ["-O2"],
+		"MODE?": ["a"]
+	}}`))
+	root.combine(parse("b.json", `{"defs": {
+		"PREFIX": ["/usr"],
+		"CFLAGS!": ["-Wall"]
+	}}`))
+
+	def, err := root.Defines("..")
+	if err != nil {
+		test.Fatal(err)
+	}
+	expected := map[string]string{
+		"CC":     "clang",
+		"CFLAGS": "-g -O2 -Wall",
+		"PREFIX": "/usr",
+		"MODE":   "root",
+	}
+	for name, v := range expected {
+		if res := strings.Join(def[name], " "); res != v {
+			test.Errorf("%s = %s, expected %s", name, res, v)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// outputFiles возвращает пути к файлам, которые создает операция при
+// обработке файлов files: значения поля outputs, в которых $(@)
+// заменяется файлами files. Утилита не групповой операции вызывается для
+// каждого файла отдельно, поэтому $(@) заменяется каждым из них.
+func (op *Operation) outputFiles(files []string) ([]string, error) {
+	if len(op.cachedOutputs) == 0 {
+		return nil, nil
+	}
+
+	groups := [][]string{files}
+	if !op.Group {
+		groups = make([][]string, len(files))
+		for i, file := range files {
+			groups[i] = []string{file}
+		}
+	}
+
+	var paths []string
+	for _, group := range groups {
+		emb := Defines{"@": group}
+		list, err := emb.substituteDefs(op.cachedOutputs, embMacroRegexp, op.s)
+		if err != nil {
+			return nil, op.errorf(err)
+		}
+		paths = append(paths, list...)
+	}
+	return paths, nil
+}
+
+// statFiles возвращает время модификации существующих файлов из paths.
+func statFiles(paths []string) (map[string]time.Time, error) {
+	files := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return nil, err
+		case !fi.IsDir():
+			files[filepath.Clean(path)] = fi.ModTime()
+		}
+	}
+	return files, nil
+}
+
+// recordOutputs отмечает в кэше объявленные файлы операции op, созданные
+// или измененные ею между снимками before и after, и возвращает их список.
+// Файлы, которые существовали до операции и не изменились, а также
+// исходные файлы, уже известные кэшу, не отмечаются.
+func (cache Cache) recordOutputs(op string, before, after map[string]time.Time) []string {
+	var produced []string
+	for path, t := range after {
+		if prev, exists := before[path]; exists && prev.Equal(t) {
+			continue
+		}
+
+		item, exists := cache[path]
+		switch {
+		case !exists:
+			item = &FileStateSnap{Path: path}
+			cache[path] = item
+		case item.Output == nil:
+			continue
+		}
+		name := op
+		item.Output = &name
+		produced = append(produced, path)
+	}
+	sort.Strings(produced)
+	return produced
+}
+
+// outputs возвращает отсортированный список файлов, созданных операциями
+// ops (всеми операциями, если ops пуст).
+func (cache Cache) outputs(ops map[string]bool) []string {
+	list := make([]string, 0, len(cache))
+	for path, item := range cache {
+		if item.Output != nil && (len(ops) == 0 || ops[*item.Output]) {
+			list = append(list, path)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// reset удаляет из кэша файлы, созданные операциями ops, и командные строки
+// этих операций, так что при следующей сборке операции обработают свои
+// исходные файлы заново.
+func (cache Cache) reset(ops map[string]bool) {
+	for _, path := range cache.outputs(ops) {
+		delete(cache, path)
+	}
+	for _, item := range cache {
+		for op := range ops {
+			delete(item.Commands, op)
+		}
+	}
+}
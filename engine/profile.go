@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// traceEvent - событие в формате Chrome Trace Event (about:tracing,
+// Perfetto), ts и dur указываются в микросекундах.
+type traceEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat"`
+	Ph   string                 `json:"ph"`
+	Ts   float64                `json:"ts"`
+	Dur  float64                `json:"dur"`
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// Profiler собирает длительности операций и вызовов утилит, получая
+// события сборки через метод Add. Операции отображаются на дорожке 0,
+// вызовы утилит - на дорожках исполнителей (Event.Worker), поэтому
+// одновременные вызовы не накладываются друг на друга.
+type Profiler struct {
+	start   time.Time
+	trace   []*traceEvent
+	execs   []*Event
+	workers map[int]bool
+}
+
+// NewProfiler начинает сбор длительностей.
+func NewProfiler() *Profiler {
+	return &Profiler{start: time.Now(), workers: make(map[int]bool)}
+}
+
+// Add учитывает событие сборки, используется как получатель событий.
+func (p *Profiler) Add(e *Event) {
+	if e.Duration == nil {
+		return
+	}
+
+	te := &traceEvent{
+		Ph:  "X",
+		Ts:  float64(e.Start.Sub(p.start)) / float64(time.Microsecond),
+		Dur: *e.Duration * 1000,
+		Pid: 1,
+	}
+
+	switch e.Event {
+	case EventOpFinish:
+		te.Name, te.Cat = e.Op, "operation"
+		te.Args = map[string]interface{}{"files": e.Files}
+	case EventExec:
+		te.Name, te.Cat = e.Argv[0], "exec"
+		te.Args = map[string]interface{}{"op": e.Op, "argv": e.Argv}
+		te.Tid = e.Worker
+		if te.Tid < 1 {
+			te.Tid = 1
+		}
+		p.workers[te.Tid] = true
+		p.execs = append(p.execs, e)
+	default:
+		return
+	}
+	if len(e.Error) != 0 {
+		te.Args["error"] = e.Error
+	}
+	p.trace = append(p.trace, te)
+}
+
+// Write сохраняет собранные длительности в формате Chrome Trace Event.
+func (p *Profiler) Write(path string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"traceEvents":     append(p.threadNames(), p.trace...),
+		"displayTimeUnit": "ms",
+	})
+	if err == nil {
+		err = ioutil.WriteFile(path, body, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("unable write profile %s: %w", path, err)
+	}
+	return nil
+}
+
+// threadNames возвращает метаданные с именами дорожек.
+func (p *Profiler) threadNames() []*traceEvent {
+	name := func(tid int, s string) *traceEvent {
+		return &traceEvent{Name: "thread_name", Ph: "M", Pid: 1, Tid: tid,
+			Args: map[string]interface{}{"name": s}}
+	}
+
+	tids := make([]int, 0, len(p.workers))
+	for tid := range p.workers {
+		tids = append(tids, tid)
+	}
+	sort.Ints(tids)
+
+	list := []*traceEvent{name(0, "operations")}
+	for _, tid := range tids {
+		list = append(list, name(tid, fmt.Sprintf("worker %d", tid)))
+	}
+	return list
+}
+
+// Summary выводит n самых долгих вызовов утилит.
+func (p *Profiler) Summary(w io.Writer, n int) {
+	if n <= 0 || len(p.execs) == 0 {
+		return
+	}
+
+	execs := append([]*Event{}, p.execs...)
+	sort.SliceStable(execs, func(i, j int) bool {
+		return *execs[i].Duration > *execs[j].Duration
+	})
+	if len(execs) > n {
+		execs = execs[:n]
+	}
+
+	fmt.Fprintf(w, "slowest %d of %d invocation(s):\n", len(execs), len(p.execs))
+	for _, e := range execs {
+		fmt.Fprintf(w, "  %10.1fms  %s: %s\n",
+			*e.Duration, e.Op, strings.Join(e.Argv, " "))
+	}
+}
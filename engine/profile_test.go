@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProfiler(test *testing.T) {
+	p := NewProfiler()
+	ms := func(v float64) *float64 { return &v }
+	files := 2
+
+	p.Add(&Event{Event: EventOpStart, Op: "cc"})
+	p.Add(&Event{Event: EventExec, Op: "cc", Argv: []string{"cc", "a.c"},
+		Start: p.start, Duration: ms(5), Worker: 1})
+	p.Add(&Event{Event: EventExec, Op: "cc", Argv: []string{"cc", "b.c"},
+		Start: p.start.Add(5 * time.Millisecond), Duration: ms(20), Worker: 2})
+	p.Add(&Event{Event: EventOpFinish, Op: "cc", Files: &files,
+		Start: p.start, Duration: ms(25)})
+
+	if len(p.trace) != 3 {
+		test.Fatalf("expected 3 trace events, actual %d", len(p.trace))
+	}
+	if te := p.trace[1]; te.Ts != 5000 || te.Dur != 20000 || te.Cat != "exec" {
+		test.Errorf("unexpected trace event %+v", te)
+	}
+	if te := p.trace[2]; te.Name != "cc" || te.Cat != "operation" || te.Tid != 0 {
+		test.Errorf("unexpected trace event %+v", te)
+	}
+	if p.trace[0].Tid != 1 || p.trace[1].Tid != 2 {
+		test.Errorf("concurrent invocations on tids %d and %d",
+			p.trace[0].Tid, p.trace[1].Tid)
+	}
+	names := p.threadNames()
+	if len(names) != 3 || names[2].Tid != 2 || names[2].Args["name"] != "worker 2" {
+		test.Errorf("unexpected thread names %+v", names)
+	}
+
+	var buf bytes.Buffer
+	p.Summary(&buf, 1)
+	expected := "slowest 1 of 2 invocation(s):\n" +
+		"        20.0ms  cc: cc b.c\n"
+	if buf.String() != expected {
+		test.Errorf("unexpected summary:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	p.Summary(&buf, 0)
+	if len(strings.TrimSpace(buf.String())) != 0 {
+		test.Errorf("unexpected summary:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Runner вызывает утилиты операций.
+type Runner interface {
+	// Run вызывает утилиту argv[0] с аргументами argv[1:] для операции op,
+	// направляя вывод утилиты в out. При неудаче возвращает ошибку;
+	// ошибка типа ToolError содержит код завершения утилиты. Run может
+	// вызываться одновременно из нескольких горутин.
+	Run(op string, argv []string, out io.Writer) error
+}
+
+// RunFunc позволяет использовать функцию в качестве Runner.
+type RunFunc func(op string, argv []string, out io.Writer) error
+
+func (f RunFunc) Run(op string, argv []string, out io.Writer) error {
+	return f(op, argv, out)
+}
+
+// ExecRunner - Runner по умолчанию, запускает утилиты с помощью os/exec.
+type ExecRunner struct{}
+
+func (ExecRunner) Run(op string, argv []string, out io.Writer) error {
+	run := exec.Command(argv[0], argv[1:]...)
+	b, err := run.CombinedOutput()
+	out.Write(b)
+	if err == nil {
+		return nil
+	}
+
+	code := -1
+	if run.ProcessState != nil {
+		code = run.ProcessState.ExitCode()
+	}
+	return &ToolError{Op: op, Argv: argv, ExitCode: code, Err: err}
+}
+
+// DryRunner ничего не запускает, а выводит командные строки в out.
+type DryRunner struct{}
+
+func (DryRunner) Run(op string, argv []string, out io.Writer) error {
+	_, err := fmt.Fprintln(out, quoteArgs(argv))
+	return err
+}
+
+// recorder записывает вызовы в w и передает их next.
+type recorder struct {
+	mu   sync.Mutex
+	enc  *json.Encoder
+	next Runner
+}
+
+// NewRecorder возвращает Runner, записывающий каждый вызов в w одной
+// строкой JSON вида {"op":"cc","argv":["cc","-c","a.c"]} (например, для
+// сравнения с эталоном в тестах) и передающий его next. Если next равен
+// nil, утилиты не вызываются. При параллельных вызовах порядок строк
+// соответствует порядку начала вызовов.
+func NewRecorder(w io.Writer, next Runner) Runner {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &recorder{enc: enc, next: next}
+}
+
+func (r *recorder) Run(op string, argv []string, out io.Writer) error {
+	r.mu.Lock()
+	err := r.enc.Encode(struct {
+		Op   string   `json:"op"`
+		Argv []string `json:"argv"`
+	}{op, argv})
+	r.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("unable record command: %w", err)
+	}
+
+	if r.next == nil {
+		return nil
+	}
+	return r.next.Run(op, argv, out)
+}
+
+// prefixer добавляет команду перед командной строкой утилиты.
+type prefixer struct {
+	prefix []string
+	next   Runner
+}
+
+// NewPrefixer возвращает Runner, вызывающий утилиты через команду prefix
+// (например, ccache или time): вызов argv передается next в виде
+// prefix + argv. Для вызова через оболочку (/bin/sh -c script) prefix
+// добавляется в начало скрипта, чтобы он относился к утилите, а не к
+// оболочке.
+func NewPrefixer(prefix []string, next Runner) Runner {
+	return &prefixer{prefix: prefix, next: next}
+}
+
+func (p *prefixer) Run(op string, argv []string, out io.Writer) error {
+	if len(argv) == 3 && argv[0] == shellPath && argv[1] == "-c" {
+		script := quoteArgs(p.prefix) + " " + argv[2]
+		return p.next.Run(op, []string{shellPath, "-c", script}, out)
+	}
+
+	full := make([]string, 0, len(p.prefix)+len(argv))
+	full = append(append(full, p.prefix...), argv...)
+	return p.next.Run(op, full, out)
+}
+
+// оболочка, через которую вызываются утилиты операций с полем shell
+const shellPath = "/bin/sh"
+
+// символы, которые не нужно экранировать в командной строке
+var shellSafeRegexp = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// quoteArg экранирует аргумент для /bin/sh.
+func quoteArg(arg string) string {
+	if shellSafeRegexp.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+// quoteArgs возвращает командную строку с экранированными аргументами.
+func quoteArgs(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = quoteArg(arg)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestExecRunner(test *testing.T) {
+	var out bytes.Buffer
+	err := ExecRunner{}.Run("op", []string{"sh", "-c", "echo out; exit 3"}, &out)
+	var terr *ToolError
+	if !errors.As(err, &terr) {
+		test.Fatalf("unexpected error %v", err)
+	}
+	if terr.Op != "op" || terr.ExitCode != 3 {
+		test.Errorf("unexpected tool error %+v", terr)
+	}
+	if out.String() != "out\n" {
+		test.Errorf("unexpected output %q", out.String())
+	}
+
+	err = ExecRunner{}.Run("op", []string{"bld-no-such-tool"}, &out)
+	if !errors.As(err, &terr) || terr.ExitCode != -1 {
+		test.Errorf("unexpected error %v", err)
+	}
+
+	if err = (ExecRunner{}).Run("op", []string{"true"}, &out); err != nil {
+		test.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestRunnerWrappers(test *testing.T) {
+	var calls []string
+	fake := RunFunc(func(op string, argv []string, out io.Writer) error {
+		calls = append(calls, op+": "+strings.Join(argv, " "))
+		return nil
+	})
+
+	var rec, out bytes.Buffer
+	r := NewRecorder(&rec, NewPrefixer([]string{"ccache"}, fake))
+	if err := r.Run("cc", []string{"cc", "-c", "a b.c"}, &out); err != nil {
+		test.Fatal(err)
+	}
+	if !equalStrings(calls, []string{"cc: ccache cc -c a b.c"}) {
+		test.Errorf("unexpected calls %q", calls)
+	}
+	expected := `{"op":"cc","argv":["cc","-c","a b.c"]}` + "\n"
+	if rec.String() != expected {
+		test.Errorf("unexpected record %s", rec.String())
+	}
+
+	calls = nil
+	sh := NewPrefixer([]string{"nice", "-n", "10"}, fake)
+	if err := sh.Run("pp", []string{"/bin/sh", "-c", "cpp a.c | wc"}, &out); err != nil {
+		test.Fatal(err)
+	}
+	if !equalStrings(calls, []string{"pp: /bin/sh -c nice -n 10 cpp a.c | wc"}) {
+		test.Errorf("unexpected calls %q", calls)
+	}
+
+	rec.Reset()
+	calls = nil
+	if err := NewRecorder(&rec, nil).Run("ld", []string{"ld"}, &out); err != nil {
+		test.Fatal(err)
+	}
+	if len(calls) != 0 || rec.Len() == 0 {
+		test.Errorf("unexpected calls %q, record %s", calls, rec.String())
+	}
+
+	err := DryRunner{}.Run("cc", []string{"cc", "-DX='1'", "a.c"}, &out)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if out.String() != `cc '-DX='\''1'\''' a.c`+"\n" {
+		test.Errorf("unexpected output %s", out.String())
+	}
+}
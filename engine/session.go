@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultMaxMacroLevel - допустимый уровень вложенности макровызовов по
+// умолчанию.
+const DefaultMaxMacroLevel = 9
+
+// session хранит параметры одного запуска, общие для загрузки сценария,
+// макроподстановки и выполнения операций. Значение nil соответствует
+// параметрам по умолчанию.
+type session struct {
+	maxLevel  int
+	strictEnv bool
+	jobs      int
+	logger    *log.Logger
+	sinks     []EventSink
+	run       Runner
+	stdout    io.Writer
+
+	// защищает получателей событий и stdout при параллельных вызовах
+	mu sync.Mutex
+}
+
+// newSession создает сессию с параметрами opts, заполняя пропущенные
+// значениями по умолчанию.
+func newSession(opts *Options) *session {
+	s := &session{maxLevel: DefaultMaxMacroLevel, jobs: 1,
+		run: ExecRunner{}, stdout: os.Stdout}
+	if opts == nil {
+		return s
+	}
+
+	s.strictEnv = opts.StrictEnv
+	s.logger = opts.Logger
+	s.sinks = opts.Events
+	if opts.MaxMacroLevel > 0 {
+		s.maxLevel = opts.MaxMacroLevel
+	}
+	if opts.Jobs > 0 {
+		s.jobs = opts.Jobs
+	}
+	if opts.DryRun {
+		s.run = DryRunner{}
+	}
+	if opts.Runner != nil {
+		s.run = opts.Runner
+	}
+	if opts.Stdout != nil {
+		s.stdout = opts.Stdout
+	}
+	return s
+}
+
+// level возвращает допустимый уровень вложенности макровызовов.
+func (s *session) level() int {
+	if s == nil {
+		return DefaultMaxMacroLevel
+	}
+	return s.maxLevel
+}
+
+// strict возвращает true, если вызов несуществующей переменной среды
+// считается ошибкой.
+func (s *session) strict() bool {
+	return s != nil && s.strictEnv
+}
+
+// parallel возвращает допустимое число одновременных вызовов утилит.
+func (s *session) parallel() int {
+	if s == nil {
+		return 1
+	}
+	return s.jobs
+}
+
+// runner возвращает способ вызова утилит.
+func (s *session) runner() Runner {
+	if s == nil {
+		return ExecRunner{}
+	}
+	return s.run
+}
+
+// logf записывает сообщение в журнал, если он указан.
+func (s *session) logf(f string, a ...interface{}) {
+	if s != nil && s.logger != nil {
+		s.logger.Printf(f, a...)
+	}
+}
+
+// emit передает событие получателям, заполняя время события и длительность
+// (если указано время начала).
+func (s *session) emit(e *Event) {
+	if s == nil || len(s.sinks) == 0 {
+		return
+	}
+
+	e.Time = time.Now()
+	if !e.Start.IsZero() {
+		ms := float64(e.Time.Sub(e.Start)) / float64(time.Millisecond)
+		e.Duration = &ms
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sink := range s.sinks {
+		sink(e)
+	}
+}
+
+// output возвращает writer для вывода утилит, безопасный при параллельных
+// вызовах.
+func (s *session) output() io.Writer {
+	if s == nil {
+		return os.Stdout
+	}
+	return &syncWriter{mu: &s.mu, w: s.stdout}
+}
+
+// syncWriter сериализует запись в w.
+type syncWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
@@ -1,6 +1,7 @@
-package main
+package engine
 
 import (
+	"fmt"
 	"io/ioutil"
 	//"log"
 	"os"
@@ -10,27 +11,51 @@ import (
 
 // getSourceDepes возвращает список путей к файлам-зависимостям для
 // указанного файла. Все пути указываются относительно рабочей директории.
-func getSourceDeps(path string, searchDirs []string) []string {
-	defer rethrow("unable get dependencies for source file %s", path)
-
+func getSourceDeps(path string, searchDirs []string) ([]string, error) {
 	ext := filepath.Ext(path)
 	if prov, ok := depsProviders[ext]; ok {
-		return searchDeps(path, prov, searchDirs)
+		deps, err := searchDeps(path, prov, searchDirs)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable get dependencies for source file %s: %w", path, err)
+		}
+		return deps, nil
 	}
 
-	return make([]string, 0)
+	return make([]string, 0), nil
+}
+
+// directDeps возвращает пути к файлам, непосредственно включаемым указанным
+// файлом, относительно рабочей директории.
+func directDeps(path string, searchDirs []string) ([]string, error) {
+	if prov, ok := depsProviders[filepath.Ext(path)]; ok {
+		names, err := prov(path)
+		if err != nil {
+			return nil, err
+		}
+		return searchFiles(names, searchDirs), nil
+	}
+	return nil, nil
 }
 
 // depsSearch обходит по дереву зависимостей для указанного файла и
 // строит список зависимостей.
-func searchDeps(path string, prov depsProvider, searchDirs []string) []string {
-	known := searchFiles(prov(path), searchDirs)
+func searchDeps(path string, prov depsProvider, searchDirs []string) ([]string, error) {
+	names, err := prov(path)
+	if err != nil {
+		return nil, err
+	}
+	known := searchFiles(names, searchDirs)
 
 	for i := 0; i < len(known); i++ {
 
 		// для каждого уже известного файла-зависимости
 		// получение зависимостей
-		deps := searchFiles(prov(known[i]), searchDirs)
+		names, err := prov(known[i])
+		if err != nil {
+			return nil, err
+		}
+		deps := searchFiles(names, searchDirs)
 		for _, d := range deps {
 
 			// добавление в список известных, если отсутствует
@@ -46,7 +71,7 @@ func searchDeps(path string, prov depsProvider, searchDirs []string) []string {
 		}
 	}
 
-	return known
+	return known, nil
 }
 
 // searchFiles ищет файлы с указанными именами в указанных директориях, 
@@ -87,7 +112,7 @@ func searchFile(name string, dirs []string) (path string) {
 // depsProvider является сигнатурой для функций,
 // реализующих получение из исходного файла имен
 // файлов-зависимостей.
-type depsProvider func(path string) []string
+type depsProvider func(path string) ([]string, error)
 
 // карта, отображающая расширение файла в функцию,
 // извлекающую зависимости.
@@ -98,7 +123,7 @@ var depsProviders = map[string]depsProvider{
 	".hpp": clangSourceDepsProvider,
 }
 
-func clangSourceDepsProvider(path string) []string {
+func clangSourceDepsProvider(path string) ([]string, error) {
 	return getRegIncl(path, clangIncludeRegexp)
 }
 
@@ -108,17 +133,11 @@ var (
 )
 
 // Получает список подключаемых файлов по регулярному выражению.
-func getRegIncl(path string, exp *regexp.Regexp) []string {
-
-	f, err := os.OpenFile(path, os.O_RDONLY, 0)
-	if err != nil {
-		panic(err)
-	}
-	defer f.Close()
+func getRegIncl(path string, exp *regexp.Regexp) ([]string, error) {
 
-	b, err := ioutil.ReadAll(f)
+	b, err := ioutil.ReadFile(path)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	inclBytes := exp.FindAllSubmatch(b, -1)
@@ -128,5 +147,5 @@ func getRegIncl(path string, exp *regexp.Regexp) []string {
 		ret[i] = string(inclBytes[i][1])
 	}
 
-	return ret
+	return ret, nil
 }
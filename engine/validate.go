@@ -0,0 +1,218 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// decodeStrict разбирает json-данные, не допуская неизвестных полей.
+func decodeStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// checkFields ищет в json-данных сценария поля, не описанные в типах
+// конфигурации, и возвращает список проблем с местами их определения.
+// Если positions равен false, указывается только имя файла.
+func checkFields(file string, data []byte, positions bool) []string {
+	w := &fieldsWalker{
+		file:      file,
+		data:      data,
+		positions: positions,
+		dec:       json.NewDecoder(bytes.NewReader(data)),
+	}
+	w.walk(reflect.TypeOf(Config{}))
+	return w.problems
+}
+
+// fieldsWalker обходит json-данные, сопоставляя их с типами конфигурации.
+type fieldsWalker struct {
+	file      string
+	data      []byte
+	positions bool
+	dec       *json.Decoder
+	problems  []string
+}
+
+// walk проверяет следующее значение, которое должно соответствовать типу t.
+// Возвращает false при ошибке разбора.
+func (w *fieldsWalker) walk(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	tok, err := w.dec.Token()
+	if err != nil {
+		return false
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		// объект может задавать элемент списка (часть макроопределения)
+		obj := t
+		if obj.Kind() == reflect.Slice {
+			obj = obj.Elem()
+			for obj.Kind() == reflect.Ptr {
+				obj = obj.Elem()
+			}
+		}
+
+		for w.dec.More() {
+			loc := location{file: w.file}
+			if w.positions {
+				loc = nextLocation(w.file, w.data, w.dec)
+			}
+			key, err := w.dec.Token()
+			if err != nil {
+				return false
+			}
+
+			var field reflect.Type
+			switch obj.Kind() {
+			case reflect.Map:
+				field = obj.Elem()
+			case reflect.Struct:
+				field = jsonField(obj, fmt.Sprint(key))
+				if field == nil {
+					w.problems = append(w.problems,
+						fmt.Sprintf("%s: unknown field \"%s\"", loc, key))
+				}
+			}
+
+			if field == nil {
+				if !skipValue(w.dec) {
+					return false
+				}
+			} else if !w.walk(field) {
+				return false
+			}
+		}
+		_, err = w.dec.Token()
+		return err == nil
+
+	case json.Delim('['):
+		for w.dec.More() {
+			if t.Kind() != reflect.Slice {
+				if !skipValue(w.dec) {
+					return false
+				}
+			} else if !w.walk(t.Elem()) {
+				return false
+			}
+		}
+		_, err = w.dec.Token()
+		return err == nil
+	}
+
+	return true
+}
+
+// jsonField возвращает тип поля структуры t, соответствующего json-ключу
+// key (с учетом встроенных структур), или nil, если поле не найдено.
+func jsonField(t reflect.Type, key string) reflect.Type {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if ft := jsonField(f.Type, key); ft != nil {
+				return ft
+			}
+			continue
+		}
+		if len(f.PkgPath) != 0 {
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+		if strings.EqualFold(name, key) {
+			return f.Type
+		}
+	}
+	return nil
+}
+
+// validate проверяет загруженную конфигурацию и возвращает список всех
+// найденных проблем. defs - развернутые глобальные макроопределения.
+func (conf *Config) validate(defs Defines) []string {
+	problems := make([]string, 0, 8)
+	report := func(op *Operation, f string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s: operation %s: ",
+			op.loc, op.Name)+fmt.Sprintf(f, a...))
+	}
+	// try сообщает об ошибке как о проблеме
+	try := func(op *Operation, err error) bool {
+		if err != nil {
+			report(op, "%s", err)
+		}
+		return err == nil
+	}
+
+	names := make(map[string]bool, len(conf.Ops))
+	for _, op := range conf.Ops {
+		names[op.Name] = true
+	}
+
+	for _, op := range conf.Ops {
+		if len(op.Tool) == 0 {
+			report(op, "tool is not specified")
+		}
+
+		for _, dep := range op.Deps {
+			if !names[dep] {
+				report(op, "dependency %s not found", dep)
+			}
+		}
+
+		if op.Group && !usesSources(op.Args) {
+			report(op, "group operation does not use $(@) in args")
+		}
+
+		scope, err := op.Scope(defs)
+		if !try(op, err) {
+			continue
+		}
+		sources, err := scope.substituteUserDefs(op.Sources, op.s)
+		if !try(op, err) {
+			continue
+		}
+		for _, src := range sources {
+			if _, err := regexp.Compile(src); err != nil {
+				report(op, "invalid sources pattern: %s", err)
+			}
+		}
+
+		_, err = scope.substituteUserDefs(op.Dirs, op.s)
+		try(op, err)
+		_, err = scope.substituteUserDefs(op.Args, op.s)
+		try(op, err)
+		_, err = scope.substituteUserDefs(op.Outputs, op.s)
+		try(op, err)
+		tool, err := scope.substituteUserDefs([]string{op.Tool}, op.s)
+		if try(op, err) && len(tool) != 1 {
+			report(op, "tool expands to %d values, expected one", len(tool))
+		}
+	}
+
+	return problems
+}
+
+// usesSources возвращает true, если аргументы содержат макровызов $(@).
+func usesSources(args []string) bool {
+	for _, arg := range args {
+		if embMacroRegexp.MatchString(arg) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/sevlyar/bld/engine"
+)
+
+// Коды завершения утилиты.
+const (
+	exitOK       = 0
+	exitTool     = 1 // утилита операции завершилась с ошибкой
+	exitUsage    = 2 // неверные опции или аргументы командной строки
+	exitConfig   = 3 // ошибка сценария или макроподстановки
+	exitInternal = 4 // внутренняя ошибка, ошибка ввода-вывода, поврежденный кэш
+)
+
+// exitCode возвращает код завершения для ошибки err. Если toolStatus равен
+// true, при ошибке утилиты возвращается ее собственный код завершения.
+func exitCode(err error, toolStatus bool) int {
+	var (
+		uerr *engine.UsageError
+		cerr *engine.ConfigError
+		merr *engine.MacroError
+		terr *engine.ToolError
+	)
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &uerr):
+		return exitUsage
+	case errors.As(err, &cerr), errors.As(err, &merr):
+		return exitConfig
+	case errors.As(err, &terr):
+		if toolStatus && terr.ExitCode > 0 {
+			return terr.ExitCode
+		}
+		return exitTool
+	}
+	return exitInternal
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sevlyar/bld/engine"
+)
+
+func TestExitCodes(test *testing.T) {
+	tool := &engine.ToolError{Op: "op", ExitCode: 3, Err: errors.New("exit status 3")}
+	cases := []struct {
+		err        error
+		toolStatus bool
+		code       int
+	}{
+		{nil, false, exitOK},
+		{&engine.UsageError{Err: errors.New("unknown option")}, false, exitUsage},
+		{&engine.ConfigError{Err: errors.New("syntax error")}, false, exitConfig},
+		{fmt.Errorf("op: %w", &engine.MacroError{Err: errors.New("cycle")}), false, exitConfig},
+		{tool, false, exitTool},
+		{tool, true, 3},
+		{&engine.ToolError{ExitCode: -1}, true, exitTool},
+		{&engine.CacheError{Path: "c", Err: errors.New("bad json")}, false, exitInternal},
+		{errors.New("i/o error"), false, exitInternal},
+	}
+	for _, c := range cases {
+		if code := exitCode(c.err, c.toolStatus); code != c.code {
+			test.Errorf("exitCode(%v, %v) = %d, expected %d",
+				c.err, c.toolStatus, code, c.code)
+		}
+	}
+}
+
+func TestOutputErrors(test *testing.T) {
+	dir := filepath.Join(os.TempDir(), "bld-no-such-dir")
+	for _, opt := range []string{"--trace", "--profile", "--record"} {
+		err := run([]string{opt + "=" + filepath.Join(dir, "f.json"), "check"})
+		if code := exitCode(err, false); code != exitUsage {
+			test.Errorf("%s: exitCode(%v) = %d, expected %d",
+				opt, err, code, exitUsage)
+		}
+		if err == nil || !strings.Contains(err.Error(), opt[2:]) {
+			test.Errorf("%s: unexpected error %v", opt, err)
+		}
+		traceFile, profileFile, recordFile = "", "", ""
+	}
+}
+
+func TestPrintProblems(test *testing.T) {
+	var out strings.Builder
+	err := fmt.Errorf("load: %w", &engine.ConfigError{Err: errors.New("2 problems"),
+		Problems: []string{"a.json:1:2: first", "a.json:3:4: second"}})
+	printProblems(&out, err)
+	printProblems(&out, errors.New("other"))
+	if out.String() != "a.json:1:2: first\na.json:3:4: second\n" {
+		test.Errorf("unexpected output %q", out.String())
+	}
+}
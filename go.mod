@@ -0,0 +1,8 @@
+module github.com/sevlyar/bld
+
+go 1.16
+
+require (
+	github.com/BurntSushi/toml v1.3.2
+	gopkg.in/yaml.v2 v2.4.0
+)
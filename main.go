@@ -1,78 +1,235 @@
 package main
 
 import (
-	"flag"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/sevlyar/bld/engine"
 )
 
+// параметры сборки, заполняются опциями
+var buildOpts engine.Options
+
 var (
-	verbose    bool
-	macroLevel int
+	verbose     bool
+	cleanOps    string
+	graphFormat string
+	graphFiles  bool
+	compdbFile  string
+	logFormat   string
+	traceFile   string
+	profileFile string
+	topN        int
+	toolStatus  bool
+	recordFile  string
+	prefixCmd   string
+	help        bool
 )
 
+var options optionSet
+
 func init() {
 	const (
 		usage_verbose    = "enable verbose output"
 		usage_macroLevel = "max level of macro"
+		usage_strictEnv  = "treat unset environment variables as errors"
+		usage_jobs       = "number of tool invocations to run in parallel"
+		usage_buildDir   = "build directory relative to the discovered root"
+		usage_workDir    = "change to the work directory before doing anything"
+		usage_cacheFile  = "cache file path relative to the work directory"
+		usage_cleanOps   = "clean command: comma-separated operations to clean"
+		usage_dryRun     = "print what would be done without doing it"
+		usage_graphFmt   = "graph command: output format (text, dot, json)"
+		usage_graphFiles = "graph command: include files from the cache"
+		usage_compdb     = "compdb command: output file"
+		usage_logFormat  = "log format: text or json (build events)"
+		usage_traceFile  = "write build events to the file as JSON lines"
+		usage_profile    = "write operation timings in Chrome Trace Event format"
+		usage_top        = "with --profile: print N slowest invocations"
+		usage_toolStatus = "exit with the exit status of the failed tool"
+		usage_record     = "write tool invocations to the file as JSON lines"
+		usage_prefix     = "run tools through the command (e.g. ccache)"
+		usage_help       = "print this help and exit"
 	)
 
-	flag.BoolVar(&verbose, "-verbose", false, usage_verbose)
-	flag.BoolVar(&verbose, "v", false, usage_verbose)
+	options.BoolVar(&verbose, 'v', "verbose", usage_verbose)
+	options.IntVar(&buildOpts.MaxMacroLevel, 'l', "level", "N",
+		engine.DefaultMaxMacroLevel, usage_macroLevel)
+	options.BoolVar(&buildOpts.StrictEnv, 's', "strict", usage_strictEnv)
+	options.IntVar(&buildOpts.Jobs, 'j', "jobs", "N", 1, usage_jobs)
+	options.StringVar(&buildOpts.BuildDir, 'b', "build-dir", "DIR", "",
+		usage_buildDir)
+	options.StringVar(&buildOpts.WorkDir, 'C', "work-dir", "DIR", "",
+		usage_workDir)
+	options.StringVar(&buildOpts.CacheFile, 0, "cache", "FILE",
+		engine.DefaultCacheFile, usage_cacheFile)
+	options.StringVar(&cleanOps, 0, "ops", "NAMES", "", usage_cleanOps)
+	options.BoolVar(&buildOpts.DryRun, 'n', "dry-run", usage_dryRun)
+	options.StringVar(&graphFormat, 0, "format", "FORMAT", "text",
+		usage_graphFmt)
+	options.BoolVar(&graphFiles, 0, "files", usage_graphFiles)
+	options.StringVar(&compdbFile, 'o', "output", "FILE", engine.DefaultCompdbFile,
+		usage_compdb)
+	options.StringVar(&logFormat, 0, "log-format", "FORMAT", "text",
+		usage_logFormat)
+	options.StringVar(&traceFile, 0, "trace", "FILE", "", usage_traceFile)
+	options.StringVar(&profileFile, 0, "profile", "FILE", "", usage_profile)
+	options.IntVar(&topN, 0, "top", "N", 10, usage_top)
+	options.BoolVar(&toolStatus, 0, "tool-status", usage_toolStatus)
+	options.StringVar(&recordFile, 0, "record", "FILE", "", usage_record)
+	options.StringVar(&prefixCmd, 0, "prefix", "CMD", "", usage_prefix)
+	options.BoolVar(&help, 'h', "help", usage_help)
+}
+
+// usage выводит справку по использованию утилиты.
+func usage() {
+	fmt.Print(`Usage: bld [OPTIONS] [COMMAND] [<scenario> [<root-dir>]]
+
+Commands:
+`)
+	for _, cmd := range commands {
+		fmt.Printf("  %-9s %s\n", cmd.name, cmd.usage)
+		fmt.Printf("  %-9s   bld %s %s\n", "", cmd.name, cmd.args)
+	}
+	fmt.Print(`
+Arguments:
+  scenario    path to the scenario relative to root-dir, the extension may be
+              omitted (default: discovered by walking up from the current
+              directory, or build)
+  root-dir    project root directory relative to the work directory
+              (default: discovered, or ..)
 
-	flag.IntVar(&macroLevel, "-level", 9, usage_macroLevel)
-	flag.IntVar(&macroLevel, "l", 9, usage_macroLevel)
+Options:
+`)
+	options.PrintDefaults(os.Stdout)
+	fmt.Print(`
+Exit status:
+  0  success
+  1  an operation tool failed (with --tool-status: the tool's exit status)
+  2  invalid options or arguments
+  3  invalid scenario or macro expansion error
+  4  internal error, I/O error or corrupt cache
+`)
 }
 
 func main() {
-	defer exit()
+	if err := run(os.Args[1:]); err != nil {
+		printProblems(os.Stdout, err)
+		fmt.Fprintln(os.Stderr, "bld:", err)
+		os.Exit(exitCode(err, toolStatus))
+	}
+}
 
-	flag.Parse()
+// printProblems выводит в w проблемы сценария, если err вызвана ими.
+func printProblems(w io.Writer, err error) {
+	var cerr *engine.ConfigError
+	if errors.As(err, &cerr) {
+		for _, p := range cerr.Problems {
+			fmt.Fprintln(w, p)
+		}
+	}
+}
 
-	if verbose {
-		log.SetFlags(log.Lmicroseconds)
-	} else {
-		log.SetOutput(ioutil.Discard)
+// createOutput создает файл what, указанный опцией (--trace, --profile,
+// --record). Ошибка считается ошибкой использования.
+func createOutput(path, what string) (*os.File, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, &engine.UsageError{Err: fmt.Errorf("unable create %s: %w",
+			what, err)}
 	}
+	return f, nil
+}
 
-	scenario := "build.json"
-	root := ".."
-	if flag.NArg() > 0 {
-		scenario = flag.Arg(0)
-		if len(filepath.Ext(scenario)) == 0 {
-			scenario += ".json"
+// run разбирает опции и аргументы командной строки argv (без имени
+// программы) и выполняет указанную команду.
+func run(argv []string) (err error) {
+	args, err := options.Parse(argv)
+	if err != nil {
+		return &engine.UsageError{Err: err}
+	}
+	if help {
+		usage()
+		return nil
+	}
+
+	switch logFormat {
+	case "text":
+		if verbose {
+			buildOpts.Logger = log.New(os.Stderr, "", log.Lmicroseconds)
 		}
-		if flag.NArg() > 1 {
-			root = flag.Arg(1)
+	case "json":
+		// вместо текстового журнала в stderr выводятся события сборки
+		buildOpts.Events = append(buildOpts.Events, engine.JSONEvents(os.Stderr))
+	default:
+		return &engine.UsageError{Err: fmt.Errorf("unknown log format '%s'",
+			logFormat)}
+	}
+	if len(traceFile) != 0 {
+		f, err := createOutput(traceFile, "trace")
+		if err != nil {
+			return err
 		}
+		defer f.Close()
+		buildOpts.Events = append(buildOpts.Events, engine.JSONEvents(f))
 	}
+	if len(profileFile) != 0 {
+		// путь указывается относительно текущей директории, профиль
+		// сохраняется после перехода в рабочую; файл создается заранее,
+		// чтобы сообщить о недоступном пути до начала сборки
+		path, aerr := filepath.Abs(profileFile)
+		if aerr != nil {
+			return &engine.UsageError{Err: aerr}
+		}
+		f, cerr := createOutput(path, "profile")
+		if cerr != nil {
+			return cerr
+		}
+		f.Close()
 
-	if wd, err := os.Getwd(); err != nil {
-		panic(err)
-	} else {
-		log.Println("work dir: ", wd)
+		p := engine.NewProfiler()
+		buildOpts.Events = append(buildOpts.Events, p.Add)
+		defer func() {
+			if werr := p.Write(path); werr != nil {
+				if err == nil {
+					err = &engine.UsageError{Err: werr}
+				} else {
+					fmt.Fprintln(os.Stderr, "bld:", werr)
+				}
+			}
+			p.Summary(os.Stdout, topN)
+		}()
 	}
-	log.Println("root dir: ", root)
-	log.Println("scenario: ", scenario)
 
-	conf := loadConfigs(scenario, root)
-
-	conf.Defs.set(".", ".")
-	conf.Defs.set("..", root)
-	conf.Defs.bootstrap()
+	if len(prefixCmd) != 0 || len(recordFile) != 0 {
+		var runner engine.Runner = engine.ExecRunner{}
+		if buildOpts.DryRun {
+			runner = engine.DryRunner{}
+		}
+		if prefix := strings.Fields(prefixCmd); len(prefix) != 0 {
+			runner = engine.NewPrefixer(prefix, runner)
+		}
+		if len(recordFile) != 0 {
+			f, err := createOutput(recordFile, "record")
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			runner = engine.NewRecorder(f, runner)
+		}
+		buildOpts.Runner = runner
+	}
 
-	cacheFile := "bldcache.json"
-	cache := ReadCache(cacheFile)
+	// без указания команды выполняется сборка: bld <scenario> <root-dir>
+	cmd, args := parseCommand(args)
 
-	for _, item := range conf.Ops {
-		fmt.Println(item.Descr)
-		item.SearchFiles(root, ".", cache, conf.Defs)
-		item.CacheOpts(conf.Defs)
-		item.Exec()
+	if buildOpts.Logger != nil {
+		buildOpts.Logger.Println("command:", cmd.name)
 	}
-
-	cache.Write(cacheFile)
+	return cmd.run(args)
 }
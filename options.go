@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// option описывает опцию командной строки.
+type option struct {
+	// короткое имя (-v), 0 если отсутствует
+	short byte
+	// длинное имя (--verbose)
+	long string
+	// имя значения для справки, пустое для опций без значения
+	arg string
+	// значение по умолчанию для справки
+	def   string
+	usage string
+	set   func(value string) error
+}
+
+// optionSet - набор опций командной строки в стиле GNU: поддерживаются
+// --long, --long=value, --long value, -s value, -svalue, объединенные
+// короткие опции (-vs) и разделитель -- , после которого все аргументы
+// считаются позиционными.
+type optionSet struct {
+	list []*option
+}
+
+// BoolVar добавляет опцию без значения, устанавливающую *p в true.
+func (s *optionSet) BoolVar(p *bool, short byte, long, usage string) {
+	s.list = append(s.list, &option{
+		short: short,
+		long:  long,
+		usage: usage,
+		set:   func(string) error { *p = true; return nil },
+	})
+}
+
+// IntVar добавляет опцию с целым значением.
+func (s *optionSet) IntVar(p *int, short byte, long, arg string, value int,
+	usage string) {
+
+	*p = value
+	opt := &option{short: short, long: long, arg: arg, usage: usage,
+		def: strconv.Itoa(value)}
+	opt.set = func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid value '%s' for option %s", v, opt.name())
+		}
+		*p = n
+		return nil
+	}
+	s.list = append(s.list, opt)
+}
+
+// StringVar добавляет опцию со строковым значением.
+func (s *optionSet) StringVar(p *string, short byte, long, arg, value,
+	usage string) {
+
+	*p = value
+	s.list = append(s.list, &option{
+		short: short,
+		long:  long,
+		arg:   arg,
+		def:   value,
+		usage: usage,
+		set:   func(v string) error { *p = v; return nil },
+	})
+}
+
+// name возвращает имя опции для сообщений.
+func (opt *option) name() string {
+	if len(opt.long) != 0 {
+		return "--" + opt.long
+	}
+	return "-" + string(opt.short)
+}
+
+// Parse разбирает аргументы командной строки, устанавливая значения
+// опций, и возвращает позиционные аргументы. Опции и позиционные
+// аргументы могут чередоваться.
+func (s *optionSet) Parse(args []string) ([]string, error) {
+	pos := make([]string, 0, len(args))
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		switch {
+		case arg == "--":
+			return append(pos, args[i+1:]...), nil
+
+		case strings.HasPrefix(arg, "--"):
+			name, value, hasValue := arg[2:], "", false
+			if j := strings.IndexByte(name, '='); j >= 0 {
+				name, value, hasValue = name[:j], name[j+1:], true
+			}
+
+			opt := s.lookup(func(o *option) bool { return o.long == name })
+			if opt == nil {
+				return nil, fmt.Errorf("unknown option --%s", name)
+			}
+
+			switch {
+			case len(opt.arg) == 0 && hasValue:
+				return nil, fmt.Errorf("option --%s doesn't take a value", name)
+			case len(opt.arg) != 0 && !hasValue:
+				if i+1 >= len(args) {
+					return nil, fmt.Errorf("option --%s requires a value", name)
+				}
+				i++
+				value = args[i]
+			}
+			if err := opt.set(value); err != nil {
+				return nil, err
+			}
+
+		case len(arg) > 1 && arg[0] == '-':
+			for j := 1; j < len(arg); j++ {
+				c := arg[j]
+				opt := s.lookup(func(o *option) bool { return o.short == c })
+				if opt == nil {
+					return nil, fmt.Errorf("unknown option -%c", c)
+				}
+
+				if len(opt.arg) == 0 {
+					opt.set("")
+					continue
+				}
+
+				// остаток аргумента или следующий аргумент - значение
+				value := arg[j+1:]
+				if len(value) == 0 {
+					if i+1 >= len(args) {
+						return nil, fmt.Errorf("option -%c requires a value", c)
+					}
+					i++
+					value = args[i]
+				}
+				if err := opt.set(value); err != nil {
+					return nil, err
+				}
+				break
+			}
+
+		default:
+			pos = append(pos, arg)
+		}
+	}
+
+	return pos, nil
+}
+
+func (s *optionSet) lookup(match func(*option) bool) *option {
+	for _, opt := range s.list {
+		if match(opt) {
+			return opt
+		}
+	}
+	return nil
+}
+
+// PrintDefaults выводит список опций с описанием.
+func (s *optionSet) PrintDefaults(w io.Writer) {
+	names := make([]string, len(s.list))
+	width := 0
+	for i, opt := range s.list {
+		name := "    "
+		if opt.short != 0 {
+			name = "-" + string(opt.short) + ", "
+		}
+		if len(opt.long) != 0 {
+			name += "--" + opt.long
+			if len(opt.arg) != 0 {
+				name += "=" + opt.arg
+			}
+		} else if len(opt.arg) != 0 {
+			name += " " + opt.arg
+		}
+
+		names[i] = name
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+
+	for i, opt := range s.list {
+		usage := opt.usage
+		if len(opt.def) != 0 {
+			usage += fmt.Sprintf(" (default %s)", opt.def)
+		}
+		fmt.Fprintf(w, "  %-*s  %s\n", width, names[i], usage)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseOptions(test *testing.T) {
+	var (
+		set    optionSet
+		a, b   bool
+		n      int
+		s1, s2 string
+	)
+	set.BoolVar(&a, 'a', "all", "")
+	set.BoolVar(&b, 'b', "", "")
+	set.IntVar(&n, 'n', "number", "N", 1, "")
+	set.StringVar(&s1, 's', "str", "S", "def", "")
+	set.StringVar(&s2, 0, "long-only", "S", "", "")
+
+	pos, err := set.Parse([]string{"x", "-ab", "--number=5", "y", "-sval",
+		"--long-only", "v2", "--", "-a", "--str=z"})
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if !a || !b || n != 5 || s1 != "val" || s2 != "v2" {
+		test.Errorf("unexpected values: %v %v %d %s %s", a, b, n, s1, s2)
+	}
+	if v := strings.Join(pos, " "); v != "x y -a --str=z" {
+		test.Errorf("positional: %s", v)
+	}
+
+	if _, err = set.Parse([]string{"-bn", "7", "-s", "w", "--str", "q"}); err != nil {
+		test.Fatal(err)
+	}
+	if n != 7 || s1 != "q" {
+		test.Errorf("unexpected values: %d %s", n, s1)
+	}
+}
+
+func TestParseOptionsErrors(test *testing.T) {
+	var (
+		set optionSet
+		a   bool
+		n   int
+	)
+	set.BoolVar(&a, 'a', "all", "")
+	set.IntVar(&n, 'n', "number", "N", 1, "")
+
+	cases := map[string][]string{
+		"unknown option --x":                    {"--x"},
+		"unknown option -z":                     {"-az"},
+		"option --all doesn't take":             {"--all=1"},
+		"option --number requires":              {"--number"},
+		"option -n requires":                    {"-an"},
+		"invalid value 'q' for option --number": {"-nq"},
+	}
+
+	for msg, args := range cases {
+		_, err := set.Parse(args)
+		if err == nil || !strings.HasPrefix(err.Error(), msg) {
+			test.Errorf("%v: unexpected error %v", args, err)
+		}
+	}
+}
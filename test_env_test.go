@@ -43,8 +43,8 @@ void f() {};
   	"defs": {
   		"-ALPHA": ["a", "b"],
   		"-NUMB": ["1", "2"],
-  		"-AB": ["{-ALPHA}", "{-NUMB}"],
-        "-TEST-DEF": ["${.}/${..}/${-ALPHA}/${-NUMB}", "$${-AB}-$${-AB}"]
+  		"-AB": ["$(-ALPHA)", "$(-NUMB)"],
+        "-TEST-DEF": ["$(.)/$(..)/$(-ALPHA)/$(-NUMB)", "$(-AB)-$(-AB)"]
     }
 }
 `),
@@ -58,11 +58,11 @@ void f() {};
 	        "name": "list-files",
 	        "descr": "list modified files",
 
-	        "sources": ".c",
-	        "dirs": ["${..}/${DIRS}"],
+	        "sources": ["\\.c$"],
+	        "dirs": ["$(..)/$(DIRS)"],
 
 	        "tool": "echo",
-	        "args": ["tool \"${@}\""]
+	        "args": ["tool \"$(@)\""]
 		}
 	]
 }